@@ -0,0 +1,154 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The parts of a concatenated title that Concat keeps in its orig folder.
+var origPartPattern = regexp.MustCompile(` - pt\d+\.[^.]+$`)
+
+// Archive describes an original kept in the orig folder of its title. It is written next to the
+// original as JSON so that the original can be put back under the name it had.
+type Archive struct {
+    Title     string      `json:"title"`
+    File      string      `json:"file"`
+    Name      string      `json:"name"`
+    Extension string      `json:"extension"`
+    Step      string      `json:"step"`
+    Archived  time.Time   `json:"archived"`
+    Probe     SourceProbe `json:"probe"`
+}
+
+// SourceProbe is what ffprobe found in a file.
+type SourceProbe struct {
+    Duration float64       `json:"duration"`
+    Bitrate  int           `json:"bitrate"`
+    Size     int64         `json:"size"`
+    Streams  []StreamProbe `json:"streams"`
+}
+
+type StreamProbe struct {
+    Type     string `json:"type"`
+    Codec    string `json:"codec"`
+    Width    int    `json:"width,omitempty"`
+    Height   int    `json:"height,omitempty"`
+    Channels int    `json:"channels,omitempty"`
+}
+
+// HasStream returns true when the file has a stream of the type, such as video or audio.
+func (p SourceProbe) HasStream(kind string) bool {
+    for _, stream := range p.Streams {
+        if stream.Type == kind {
+            return true
+        }
+    }
+    return false
+}
+
+func probeSource(path string) (SourceProbe, error) {
+    probe := SourceProbe{}
+    stdout, err := Ffprobe(
+        "-show_entries", "format=duration,bit_rate,size:stream=codec_type,codec_name,width,height,channels",
+        "-of", "json",
+        path)
+    if err != nil {
+        return probe, err
+    }
+    result := struct {
+        Format struct {
+            Duration string `json:"duration"`
+            Bitrate  string `json:"bit_rate"`
+            Size     string `json:"size"`
+        } `json:"format"`
+        Streams []struct {
+            CodecType string `json:"codec_type"`
+            CodecName string `json:"codec_name"`
+            Width     int    `json:"width"`
+            Height    int    `json:"height"`
+            Channels  int    `json:"channels"`
+        } `json:"streams"`
+    }{}
+    if err = json.Unmarshal(stdout, &result); err != nil {
+        return probe, err
+    }
+    if probe.Duration, err = strconv.ParseFloat(result.Format.Duration, 64); err != nil {
+        return probe, fmt.Errorf("no duration")
+    }
+    probe.Size, _ = strconv.ParseInt(result.Format.Size, 10, 64)
+    probe.Bitrate, _ = strconv.Atoi(result.Format.Bitrate)
+    if probe.Bitrate <= 0 && probe.Duration > 0 {
+        probe.Bitrate = int(float64(probe.Size) * 8 / probe.Duration)
+    }
+    for _, stream := range result.Streams {
+        probe.Streams = append(probe.Streams, StreamProbe{stream.CodecType, stream.CodecName, stream.Width, stream.Height, stream.Channels})
+    }
+    return probe, nil
+}
+
+// Moves the title's video into the orig folder of the title as "Title - 8500kbps.mkv", next to a
+// sidecar describing it. The step is the step that is replacing it: video or audio. Returns
+// where the original was moved to.
+func archiveOriginal(m *Media, step string) (string, error) {
+    source := m.Video().Path()
+    probe, err := probeSource(source)
+    if err != nil {
+        return "", fmt.Errorf("unable to probe %s: %v", source, err)
+    }
+    dir := Mkdir(filepath.Join(m.Path(), "orig"))
+    if dir == "" {
+        return "", fmt.Errorf("unable to make the orig folder")
+    }
+    archive := Archive{}
+    archive.Title = m.Name()
+    archive.Name = filepath.Base(source)
+    archive.Extension = m.Extension()
+    archive.Step = step
+    archive.Archived = time.Now()
+    archive.Probe = probe
+    archive.File = archiveName(dir, m.Name(), probe.Bitrate, filepath.Ext(source))
+    sidecar := filepath.Join(dir, strings.TrimSuffix(archive.File, filepath.Ext(archive.File)) + ".json")
+    data, _ := json.MarshalIndent(archive, "", "  ")
+    if !Write(sidecar, string(data)) {
+        return "", fmt.Errorf("unable to write %s", sidecar)
+    }
+    applyDefaultAttributes(sidecar)
+    target := filepath.Join(dir, archive.File)
+    if err = Move(source, target); err != nil {
+        os.Remove(sidecar)
+        return "", err
+    }
+    return target, nil
+}
+
+// Returns a name for the original in the orig folder that is not taken yet.
+func archiveName(dir string, title string, bitrate int, extension string) string {
+    base := fmt.Sprintf("%s - %vkbps", title, bitrate / 1000)
+    name := base + extension
+    for i := 2; PathExists(filepath.Join(dir, name)); i++ {
+        name = fmt.Sprintf("%s (%v)%s", base, i, extension)
+    }
+    return name
+}
+
+// Returns the archive described by the sidecar.
+func readArchive(sidecar string) (Archive, error) {
+    archive := Archive{}
+    data, err := os.ReadFile(sidecar)
+    if err != nil {
+        return archive, err
+    }
+    if err = json.Unmarshal(data, &archive); err != nil {
+        return archive, err
+    }
+    if archive.File == "" {
+        return archive, fmt.Errorf("%s describes no original", sidecar)
+    }
+    return archive, nil
+}
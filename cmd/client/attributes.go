@@ -0,0 +1,113 @@
+package main
+
+import (
+    "os"
+    "os/user"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The owner, group, mode and modification time of a file. The owner and group are only known
+// where the platform has them.
+type fileAttributes struct {
+    uid   int
+    gid   int
+    owned bool
+    mode  os.FileMode
+    mtime time.Time
+}
+
+// Reads the attributes of the file, such as an original before it is replaced.
+func readAttributes(path string) (fileAttributes, error) {
+    attributes := fileAttributes{}
+    info, err := os.Stat(path)
+    if err != nil {
+        return attributes, err
+    }
+    attributes.mode = info.Mode().Perm()
+    attributes.mtime = info.ModTime()
+    attributes.uid, attributes.gid, attributes.owned = fileOwner(info)
+    return attributes, nil
+}
+
+// Gives the replacement the owner, group and mode of the original and, with -keepMtime, its
+// modification time, so that the media server can still read it. The -owner and -fileMode
+// flags are used for what could not be read from the original.
+func inheritAttributes(original fileAttributes, replacement string) {
+    uid, gid, owned := original.uid, original.gid, original.owned
+    if !owned {
+        uid, gid, owned = GetParameters().Owner()
+    }
+    if owned {
+        if err := os.Chown(replacement, uid, gid); err != nil {
+            // Without root the owner cannot be given away, but the group can be when the user is
+            // one of its members.
+            if gid >= 0 && os.Chown(replacement, -1, gid) == nil {
+                Warnf("Unable to give %s the owner %v of the original, only its group: %v", replacement, uid, err)
+            } else {
+                Warnf("Unable to give %s the owner %v:%v of the original: %v", replacement, uid, gid, err)
+            }
+        }
+    } else {
+        Warnf("Unable to give %s the owner of the original: the owner is unknown on this platform. Supply -owner to set it.", replacement)
+    }
+    mode := original.mode
+    if mode == 0 {
+        mode = GetParameters().FileMode()
+    }
+    if mode != 0 {
+        if err := os.Chmod(replacement, mode); err != nil {
+            Warnf("Unable to give %s the mode %v of the original: %v", replacement, mode, err)
+        }
+    }
+    if GetParameters().KeepMtime() && !original.mtime.IsZero() {
+        if err := os.Chtimes(replacement, time.Now(), original.mtime); err != nil {
+            Warnf("Unable to give %s the modification time of the original: %v", replacement, err)
+        }
+    }
+}
+
+// Gives a file the tool made in the library without an original, such as a review file, the
+// owner and mode of -owner and -fileMode when they are supplied.
+func applyDefaultAttributes(path string) {
+    if uid, gid, owned := GetParameters().Owner(); owned {
+        if err := os.Chown(path, uid, gid); err != nil {
+            Warnf("Unable to give %s the owner %v:%v: %v", path, uid, gid, err)
+        }
+    }
+    if mode := GetParameters().FileMode(); mode != 0 {
+        if err := os.Chmod(path, mode); err != nil {
+            Warnf("Unable to give %s the mode %v: %v", path, mode, err)
+        }
+    }
+}
+
+// Parses an owner given as user:group, by name or by id. The group defaults to the user's
+// primary group. Returns the group -1, which keeps the group a file already has, when the user
+// is only known by id.
+func parseOwner(owner string) (int, int, error) {
+    name, group, hasGroup := strings.Cut(owner, ":")
+    uid, err := strconv.Atoi(name)
+    gid := -1
+    if err != nil {
+        account, err := user.Lookup(name)
+        if err != nil {
+            return 0, 0, err
+        }
+        uid, _ = strconv.Atoi(account.Uid)
+        gid, _ = strconv.Atoi(account.Gid)
+    } else if account, err := user.LookupId(name); err == nil {
+        gid, _ = strconv.Atoi(account.Gid)
+    }
+    if hasGroup {
+        if gid, err = strconv.Atoi(group); err != nil {
+            found, err := user.LookupGroup(group)
+            if err != nil {
+                return 0, 0, err
+            }
+            gid, _ = strconv.Atoi(found.Gid)
+        }
+    }
+    return uid, gid, nil
+}
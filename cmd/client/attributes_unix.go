@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// Returns the owner and group of the file.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+    st, ok := info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return 0, 0, false
+    }
+    return int(st.Uid), int(st.Gid), true
+}
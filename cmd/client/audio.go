@@ -1,8 +1,6 @@
 package main
 
 import (
-    "os/exec"
-    "strings"
     "strconv"
 )
 
@@ -20,15 +18,7 @@ func (a *Audio) Channels() int {
 	if a.channels != nil {
 		return *a.channels
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "a:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=channels",
-		a.path)
-    stdout, _ := cmd.Output()
-    channels, _ := strconv.Atoi(strings.TrimSuffix(string(stdout), "\n"))
+    channels, _ := strconv.Atoi(ProbeEntry(a.path, "a:0", "stream=channels"))
     a.channels = &channels
     return *a.channels
 }
@@ -37,15 +27,7 @@ func (a *Audio) Bitrate() int {
 	if a.bitrate != nil {
 		return *a.bitrate
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "a:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=bit_rate",
-		a.path)
-    stdout, _ := cmd.Output()
-    bitrateStr := strings.TrimSuffix(string(stdout), "\n")
+    bitrateStr := ProbeEntry(a.path, "a:0", "stream=bit_rate")
     if bitrateStr == "" || bitrateStr == "N/A" {
     	bitrate := -1
     	a.bitrate = &bitrate
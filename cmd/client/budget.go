@@ -0,0 +1,173 @@
+package main
+
+import (
+    "encoding/json"
+    "math"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+)
+
+// The lowest share of the average bitrate that a scene is given.
+const minBudgetShare = 0.5
+
+// SceneBudget records the complexity of a scene and the bitrate it was given.
+type SceneBudget struct {
+    Chunk      int     `json:"chunk"`
+    Seconds    float64 `json:"seconds"`
+    Complexity int     `json:"complexity"`
+    Bitrate    int     `json:"bitrate"`
+}
+
+// Shares a budget out across the scenes by how complex they are, so that high-motion scenes are
+// given more than quiet scenes. The complexity of a scene is the bitrate of a fast, low resolution
+// encode of the scene. The budget averages 10% below maxBitrate, like the two-pass encodes, and no
+// scene is given more than the peak of -peakBitrate. Scenes encoded at a constant quality are
+// capped at their share, while two-pass encodes aim for it. The complexities are recorded in
+// budget.json in the title's work directory so that they are reused when the title is resumed.
+func sceneBitrates(path string, v *Video, segments []*Segment, maxBitrate int) []int {
+    record := filepath.Join(path, "budget.json")
+    budgets := readBudget(record)
+    if len(budgets) != len(segments) {
+        budgets = analyzeScenes(v, segments)
+        // An interrupted analysis is incomplete and is done again when the title is resumed.
+        if !Interrupted() {
+            data, _ := json.MarshalIndent(budgets, "", "  ")
+            Write(record, string(data))
+        }
+    }
+    shareBudget(budgets, maxBitrate - maxBitrate / 10, scenePeak(maxBitrate))
+    bitrates := make([]int, len(budgets))
+    for i, budget := range budgets {
+        bitrates[i] = budget.Bitrate
+        Logf(LevelInfo, i, "The scene has a complexity of %v bps and a budget of %v bps.", budget.Complexity, budget.Bitrate)
+    }
+    return bitrates
+}
+
+// Returns the most a scene of the video may be given: -peakBitrate less the share of -bitrate that
+// is left for the audio.
+func scenePeak(maxBitrate int) int {
+    return maxBitrate + GetParameters().PeakBitrate() - GetParameters().Bitrate()
+}
+
+// Runs the fast encodes of the scenes in parallel.
+func analyzeScenes(v *Video, segments []*Segment) []SceneBudget {
+    ProgressStep("Analyzing scene complexity")
+    budgets := make([]SceneBudget, len(segments))
+    var wg sync.WaitGroup
+    var sem = make(chan int, GetParameters().Cores())
+    for i, segment := range segments {
+        if Interrupted() {
+            break
+        }
+        wg.Add(1)
+        sem <- 1
+        go func(segment *Segment, i int) {
+            defer wg.Done()
+            budgets[i] = analyzeScene(v, segment, i)
+            <-sem
+        }(segment, i)
+    }
+    wg.Wait()
+    return budgets
+}
+
+func analyzeScene(v *Video, segment *Segment, count int) SceneBudget {
+    budget := SceneBudget{Chunk: count, Seconds: segment.Seconds()}
+    probe := TrackTmp(v.Path() + ".complexity.pt" + strconv.Itoa(count) + ".mp4")
+    defer RemoveTmp(probe)
+    params := []string{}
+    params = append(params, "-i", segment.Path)
+    params = append(params, "-map", "0:v:0")
+    params = append(params, "-vf", v.Crop().Filter() + ",scale=-2:240")
+    params = append(params, "-threads", "1")
+    params = append(params, "-vcodec", "libx264")
+    params = append(params, "-preset", "ultrafast")
+    params = append(params, "-crf", "23")
+    params = append(params, "-an")
+    params = append(params, "-f", "mp4")
+    params = append(params, "-y")
+    params = append(params, probe)
+    err := RunFfmpeg(params, budget.Seconds, untracked)
+    info, statErr := os.Stat(probe)
+    if err != nil || statErr != nil || budget.Seconds <= 0 {
+        Logf(LevelWarn, count, "Failed to analyze the complexity of the scene: %v", err)
+        return budget
+    }
+    budget.Complexity = int(float64(info.Size()) * 8 / budget.Seconds)
+    return budget
+}
+
+// Gives each scene a share of the average bitrate that grows with the square root of its
+// complexity. Shares above the peak are clamped first, then shares below the minimum, and the
+// difference is shared out again among the other scenes. The average is lowered to the peak when
+// it is above it.
+func shareBudget(budgets []SceneBudget, average int, peak int) {
+    if average > peak {
+        average = peak
+    }
+    low := int(float64(average) * minBudgetShare)
+    clamped := make([]bool, len(budgets))
+    for i := range budgets {
+        budgets[i].Bitrate = average
+        // Scenes that could not be analyzed keep the average bitrate.
+        if budgets[i].Complexity <= 0 {
+            clamped[i] = true
+        }
+    }
+    for pass := 0; pass < len(budgets); pass++ {
+        // The bits left for the scenes that are not clamped.
+        bits := float64(0)
+        weights := float64(0)
+        for i, budget := range budgets {
+            bits += float64(average) * budget.Seconds
+            if clamped[i] {
+                bits -= float64(budget.Bitrate) * budget.Seconds
+            } else {
+                weights += math.Sqrt(float64(budget.Complexity)) * budget.Seconds
+            }
+        }
+        if weights <= 0 {
+            return
+        }
+        high := false
+        for i, budget := range budgets {
+            if !clamped[i] {
+                budgets[i].Bitrate = int(bits * math.Sqrt(float64(budget.Complexity)) / weights)
+                high = high || budgets[i].Bitrate > peak
+            }
+        }
+        // The scenes below the minimum may end up above it once the scenes above the peak have
+        // given up their excess.
+        changed := false
+        for i, budget := range budgets {
+            if clamped[i] {
+                continue
+            }
+            if budget.Bitrate > peak {
+                budgets[i].Bitrate = peak
+                clamped[i] = true
+                changed = true
+            } else if !high && budget.Bitrate < low {
+                budgets[i].Bitrate = low
+                clamped[i] = true
+                changed = true
+            }
+        }
+        if !changed {
+            return
+        }
+    }
+}
+
+func readBudget(record string) []SceneBudget {
+    budgets := make([]SceneBudget, 0)
+    data, err := os.ReadFile(record)
+    if err != nil {
+        return budgets
+    }
+    json.Unmarshal(data, &budgets)
+    return budgets
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+// Returns the average bitrate of the scenes weighted by how long they last.
+func averageBitrate(budgets []SceneBudget) float64 {
+    bits := float64(0)
+    seconds := float64(0)
+    for _, budget := range budgets {
+        bits += float64(budget.Bitrate) * budget.Seconds
+        seconds += budget.Seconds
+    }
+    return bits / seconds
+}
+
+func TestShareBudget(t *testing.T) {
+    tests := []struct {
+        description string
+        budgets     []SceneBudget
+        average     int
+        peak        int
+    }{
+        {
+            "similar scenes",
+            []SceneBudget{{Seconds: 10, Complexity: 400000}, {Seconds: 20, Complexity: 500000}, {Seconds: 5, Complexity: 450000}},
+            1800000,
+            2000000,
+        },
+        {
+            "a complex scene clamped at the peak",
+            []SceneBudget{{Seconds: 30, Complexity: 9000000}, {Seconds: 30, Complexity: 300000}, {Seconds: 30, Complexity: 400000}},
+            1800000,
+            2000000,
+        },
+        {
+            "a quiet scene clamped at the minimum",
+            []SceneBudget{{Seconds: 60, Complexity: 10}, {Seconds: 60, Complexity: 900000}, {Seconds: 60, Complexity: 1000000}},
+            1800000,
+            4000000,
+        },
+        {
+            "a scene that could not be analyzed",
+            []SceneBudget{{Seconds: 10, Complexity: 0}, {Seconds: 10, Complexity: 200000}, {Seconds: 10, Complexity: 800000}},
+            1800000,
+            2000000,
+        },
+    }
+    for _, test := range tests {
+        shareBudget(test.budgets, test.average, test.peak)
+        low := int(float64(test.average) * minBudgetShare)
+        for i, budget := range test.budgets {
+            if budget.Bitrate > test.peak {
+                t.Errorf("%s: scene %v was given %v; more than the peak of %v", test.description, i, budget.Bitrate, test.peak)
+            }
+            if budget.Bitrate < low {
+                t.Errorf("%s: scene %v was given %v; less than the minimum of %v", test.description, i, budget.Bitrate, low)
+            }
+            if budget.Complexity <= 0 && budget.Bitrate != test.average {
+                t.Errorf("%s: scene %v was not analyzed but was given %v; want the average of %v", test.description, i, budget.Bitrate, test.average)
+            }
+        }
+        // The shares are rounded down to whole bits per second.
+        if average := averageBitrate(test.budgets); math.Abs(average - float64(test.average)) > 1 {
+            t.Errorf("%s: the scenes average %.0f; want %v", test.description, average, test.average)
+        }
+    }
+}
+
+func TestShareBudgetOrder(t *testing.T) {
+    budgets := []SceneBudget{{Seconds: 10, Complexity: 100000}, {Seconds: 10, Complexity: 400000}, {Seconds: 10, Complexity: 900000}}
+    shareBudget(budgets, 1800000, 4000000)
+    if !(budgets[0].Bitrate < budgets[1].Bitrate && budgets[1].Bitrate < budgets[2].Bitrate) {
+        t.Errorf("more complex scenes should be given more: %v, %v, %v", budgets[0].Bitrate, budgets[1].Bitrate, budgets[2].Bitrate)
+    }
+}
+
+func TestShareBudgetAboveThePeak(t *testing.T) {
+    budgets := []SceneBudget{{Seconds: 10, Complexity: 100000}, {Seconds: 10, Complexity: 900000}}
+    shareBudget(budgets, 2000000, 1500000)
+    for i, budget := range budgets {
+        if budget.Bitrate > 1500000 {
+            t.Errorf("scene %v was given %v; more than the peak of 1500000", i, budget.Bitrate)
+        }
+    }
+    if average := averageBitrate(budgets); average > 1500000 {
+        t.Errorf("the scenes average %.0f; more than the peak of 1500000", average)
+    }
+}
+
+func TestShareBudgetAboveTheBitrate(t *testing.T) {
+    testParameters()
+    maxBitrate := GetParameters().Bitrate() - 128000
+    peak := scenePeak(maxBitrate)
+    if peak <= maxBitrate {
+        t.Fatalf("the peak of %v is not above the bitrate of %v", peak, maxBitrate)
+    }
+    budgets := []SceneBudget{{Seconds: 60, Complexity: 100000}, {Seconds: 60, Complexity: 200000}, {Seconds: 20, Complexity: 2000000}}
+    shareBudget(budgets, maxBitrate - maxBitrate / 10, peak)
+    if budgets[2].Bitrate <= maxBitrate {
+        t.Errorf("the complex scene was given %v; want more than the bitrate of %v", budgets[2].Bitrate, maxBitrate)
+    }
+    if average := averageBitrate(budgets); math.Abs(average - float64(maxBitrate - maxBitrate / 10)) > 1 {
+        t.Errorf("the scenes average %.0f; want %v", average, maxBitrate - maxBitrate / 10)
+    }
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "math"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The number of seconds decoded at the start, middle and end of a replacement before its
+// originals are discarded.
+const decodeSampleSeconds = 10
+
+// The file in the metadata directory that every discarded original is recorded in.
+const AuditFile = "audit.log"
+
+// The originals of the parts of a concatenated title, which are kept by scale.
+var scaledPartPattern = regexp.MustCompile(` - pt\d+\.[^.]+\.orig$`)
+
+var auditLock sync.Mutex
+
+// Returns the originals kept in the title's folder: the orig folder, which holds the parts of a
+// concatenated title and the archived originals, and the files ending with ".orig", which are
+// the parts kept by scale and the originals kept before they were archived.
+func findOriginals(path string) []string {
+    originals := make([]string, 0)
+    files, err := ioutil.ReadDir(path)
+    if err != nil {
+        Errorf("%v", err)
+        return originals
+    }
+    for _, file := range files {
+        if (file.IsDir() && file.Name() == "orig") || (!file.IsDir() && strings.HasSuffix(file.Name(), ".orig")) {
+            originals = append(originals, filepath.Join(path, file.Name()))
+        }
+    }
+    return originals
+}
+
+// Checks that the replacement can stand in for the originals before they are discarded: it can
+// be probed, has a video stream and every other kind of stream the originals have, such as audio,
+// lasts as long as each original within the duration tolerance and decodes without errors.
+func verifyReplacement(replacement string, originals []string) error {
+    probe, err := probeSource(replacement)
+    if err != nil {
+        return fmt.Errorf("unable to probe it: %v", err)
+    }
+    if !probe.HasStream("video") {
+        return fmt.Errorf("it has no video stream")
+    }
+    for _, original := range originals {
+        // The parts are compared together through the orig folder.
+        if scaledPartPattern.MatchString(original) {
+            continue
+        }
+        probes, err := originalProbes(original)
+        if err != nil {
+            return fmt.Errorf("unable to probe the original %s: %v", original, err)
+        }
+        for name, expected := range probes {
+            if !withinSeconds(probe.Duration, expected.Duration) {
+                return fmt.Errorf("it lasts %.3f seconds but the original %s lasts %.3f", probe.Duration, name, expected.Duration)
+            }
+            if expected.HasStream("audio") && !probe.HasStream("audio") {
+                return fmt.Errorf("it has no audio stream but the original %s has", name)
+            }
+        }
+    }
+    return decodeSamples(replacement, probe.Duration)
+}
+
+// Probes each video the original holds. The parts of a concatenated title in the orig folder are
+// added together; the originals archived there are each their own.
+func originalProbes(original string) (map[string]SourceProbe, error) {
+    probes := make(map[string]SourceProbe)
+    info, err := os.Stat(original)
+    if err != nil {
+        return probes, err
+    }
+    if !info.IsDir() {
+        probe, err := probeSource(original)
+        probes[original] = probe
+        return probes, err
+    }
+    files, err := ioutil.ReadDir(original)
+    if err != nil {
+        return probes, err
+    }
+    for _, file := range files {
+        if file.IsDir() || !isVideoExtension(strings.TrimPrefix(filepath.Ext(file.Name()), ".")) {
+            continue
+        }
+        path := filepath.Join(original, file.Name())
+        probe, err := probeSource(path)
+        if err != nil {
+            return probes, err
+        }
+        if origPartPattern.MatchString(file.Name()) {
+            parts := probes[original]
+            parts.Duration += probe.Duration
+            parts.Streams = append(parts.Streams, probe.Streams...)
+            probes[original] = parts
+        } else {
+            probes[path] = probe
+        }
+    }
+    return probes, nil
+}
+
+// Decodes a few seconds at the start, middle and end of the file. Any error the decoder logs
+// fails the check.
+func decodeSamples(path string, seconds float64) error {
+    for _, start := range []float64{0, (seconds - decodeSampleSeconds) / 2, seconds - decodeSampleSeconds} {
+        start = math.Max(start, 0)
+        params := []string{}
+        params = append(params, "-v", "error")
+        params = append(params, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+        params = append(params, "-t", strconv.Itoa(decodeSampleSeconds))
+        params = append(params, "-i", path)
+        params = append(params, "-map", "0:v:0")
+        params = append(params, "-map", "0:a:0?")
+        params = append(params, "-f", "null")
+        params = append(params, "-")
+        _, stderr, err := Ffmpeg(params...)
+        if err != nil {
+            return fmt.Errorf("unable to decode it at %.0f seconds: %v", start, err)
+        }
+        if output := strings.TrimSpace(string(stderr)); output != "" {
+            return fmt.Errorf("decoding it at %.0f seconds failed: %s", start, strings.SplitN(output, "\n", 2)[0])
+        }
+    }
+    return nil
+}
+
+// Moves the original into the batch of the trash, keeping its path relative to the library root,
+// or deletes it when there is no batch. Each file is recorded in the audit file.
+func discard(root string, batch string, original string, replacement string) {
+    files, sizes := listFiles(original)
+    action := "deleted"
+    if batch == "" {
+        Infof("Deleting %s.", original)
+        if err := os.RemoveAll(original); err != nil {
+            Errorf("Failed to discard %s: %v", original, err)
+        }
+    } else {
+        action = "trashed"
+        relative, err := filepath.Rel(root, original)
+        if err != nil {
+            Errorf("Failed to move %s to the trash: %v", original, err)
+            return
+        }
+        target := filepath.Join(batch, relative)
+        Infof("Moving %s to the trash.", original)
+        if Mkdir(filepath.Dir(target)) == "" {
+            return
+        }
+        if err = Move(original, target); err != nil {
+            Errorf("Failed to move %s to the trash: %v", original, err)
+        }
+    }
+    for i, path := range files {
+        if !PathExists(path) {
+            audit(action, path, sizes[i], replacement)
+        }
+    }
+}
+
+// Returns the files in the path, or the path itself when it is a file, and their sizes.
+func listFiles(path string) ([]string, []int64) {
+    files := make([]string, 0)
+    sizes := make([]int64, 0)
+    filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() {
+            files = append(files, file)
+            sizes = append(sizes, info.Size())
+        }
+        return nil
+    })
+    return files, sizes
+}
+
+// Appends a line to the audit file. The fields are separated by tabs: the time, the action, the
+// file, its size in bytes and the file that replaced it or, when it was restored, where it was
+// restored to.
+func audit(action string, path string, size int64, replacement string) {
+    auditLock.Lock()
+    defer auditLock.Unlock()
+    file, err := os.OpenFile(filepath.Join(DefaultMetadataDir(), AuditFile), os.O_CREATE | os.O_APPEND | os.O_WRONLY, 0644)
+    if err != nil {
+        Errorf("Failed to open the audit file: %v", err)
+        return
+    }
+    defer file.Close()
+    fmt.Fprintf(file, "%s\t%s\t%s\t%v\t%s\n", time.Now().Format(time.RFC3339), action, path, size, replacement)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strconv"
+    "text/tabwriter"
+    "time"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// DaemonRunning returns true when a daemon is answering on the API address.
+func DaemonRunning() bool {
+    probe := &http.Client{Timeout: time.Second}
+    resp, err := probe.Get(apiUrl("/jobs?status=running"))
+    if err != nil {
+        return false
+    }
+    resp.Body.Close()
+    return resp.StatusCode == http.StatusOK
+}
+
+// EnqueueRemote asks the daemon to add the title to its queue.
+func EnqueueRemote(kind string, root string, title string) (Job, error) {
+    job := Job{}
+    err := request(http.MethodPost, "/jobs", enqueueRequest{kind, root, title}, &job)
+    return job, err
+}
+
+// PrintJobs lists the daemon's jobs whose title matches the filter.
+func PrintJobs() {
+    jobs := make([]Job, 0)
+    if err := request(http.MethodGet, "/jobs", nil, &jobs); err != nil {
+        Errorf("Failed to list jobs: %v", err)
+        return
+    }
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "ID\tSTATUS\tKIND\tTITLE\tATTEMPTS\tERROR")
+    for _, job := range jobs {
+        if !GetParameters().Matches(job.Title) {
+            continue
+        }
+        fmt.Fprintf(w, "%v\t%s\t%s\t%s\t%v\t%s\n", job.Id, job.Status, job.Kind, job.Title, job.Attempts, job.Error)
+    }
+    w.Flush()
+}
+
+// UpdateJob sends the cancel, retry or move action for the job to the daemon.
+func UpdateJob(action string, id int) {
+    job := Job{}
+    var body interface{}
+    if action == "move" {
+        body = moveRequest{GetParameters().Position()}
+    }
+    if err := request(http.MethodPost, "/jobs/" + strconv.Itoa(id) + "/" + action, body, &job); err != nil {
+        Errorf("Failed to %s job %v: %v", action, id, err)
+        return
+    }
+    Infof("Job %v: %s %s is %s.", job.Id, job.Kind, job.Title, job.Status)
+}
+
+func apiUrl(path string) string {
+    return "http://" + GetParameters().Api() + path
+}
+
+func request(method string, path string, body interface{}, result interface{}) error {
+    data := []byte{}
+    if body != nil {
+        encoded, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        data = encoded
+    }
+    req, err := http.NewRequest(method, apiUrl(path), bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        failure := apiError{}
+        if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Error == "" {
+            return errors.New(resp.Status)
+        }
+        return errors.New(failure.Error)
+    }
+    return json.NewDecoder(resp.Body).Decode(result)
+}
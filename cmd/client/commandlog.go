@@ -0,0 +1,139 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// The number of lines from the end of a command's log that are added to its failure message.
+const logTailLines = 5
+// How much of the end of a command's stderr is kept in memory for the failure message.
+const logTailBytes = 8192
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Returns the log directory of the current run of the title being processed, making it and
+// discarding the logs of older runs the first time it is needed. Returns an empty string when no
+// title is being processed, logs are turned off or the directory cannot be made. The directory is
+// made without the progress lock held, since failing to make it is logged.
+func commandLogDir() string {
+    progressLock.Lock()
+    current := progress
+    if current == nil || current.runLogs != "" || current.runLogsFailed || current.logPath == "" || GetParameters().KeepLogs() < 1 {
+        dir := ""
+        if current != nil {
+            dir = current.runLogs
+        }
+        progressLock.Unlock()
+        return dir
+    }
+    titleLogs := filepath.Join(filepath.Dir(current.logPath), "logs", current.Title)
+    run := filepath.Join(titleLogs, current.Started.Format("20060102-150405"))
+    progressLock.Unlock()
+    if err := os.MkdirAll(run, os.ModePerm); err != nil {
+        Warnf("Unable to make the log directory %s: %v", run, err)
+        progressLock.Lock()
+        current.runLogsFailed = true
+        progressLock.Unlock()
+        return ""
+    }
+    pruneLogs(titleLogs, GetParameters().KeepLogs())
+    progressLock.Lock()
+    current.runLogs = run
+    progressLock.Unlock()
+    return run
+}
+
+// Removes all but the newest runs from the title's log directory.
+func pruneLogs(titleLogs string, keep int) {
+    runs, err := ioutil.ReadDir(titleLogs)
+    if err != nil {
+        return
+    }
+    // Runs are named by the time they started, so the names sort oldest first.
+    sort.Slice(runs, func(i, j int) bool {
+        return runs[i].Name() < runs[j].Name()
+    })
+    for i := 0; i < len(runs) - keep; i++ {
+        os.RemoveAll(filepath.Join(titleLogs, runs[i].Name()))
+    }
+}
+
+// Opens a log file for a command in the current run's log directory and writes the command line
+// at the top. The file is named after the chunk the command works on, or otherwise the current
+// step. Returns nil when there is nowhere to log to.
+func openCommandLog(name string, args []string, chunk int) *os.File {
+    dir := commandLogDir()
+    progressLock.Lock()
+    if progress == nil {
+        progressLock.Unlock()
+        return nil
+    }
+    progress.logCount++
+    label := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(progress.Step), "-"), "-")
+    if chunk >= 0 {
+        label = fmt.Sprintf("scene%v", chunk)
+    }
+    file := filepath.Join(dir, fmt.Sprintf("%04d-%s-%s.log", progress.logCount, label, name))
+    progressLock.Unlock()
+    if dir == "" {
+        return nil
+    }
+    log, err := os.Create(file)
+    if err != nil {
+        return nil
+    }
+    fmt.Fprintf(log, "%s\n\n", FormatCommand(name, args))
+    return log
+}
+
+// Keeps the end of what is written to it.
+type tailBuffer struct {
+    lock sync.Mutex
+    data []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    t.data = append(t.data, p...)
+    if len(t.data) > logTailBytes {
+        t.data = append([]byte{}, t.data[len(t.data) - logTailBytes:]...)
+    }
+    return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    return t.data
+}
+
+// Returns the last lines of the command's stderr, indented below the failure message.
+func logTail(stderr []byte) string {
+    lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+    if len(lines) > logTailLines {
+        lines = lines[len(lines) - logTailLines:]
+    }
+    tail := ""
+    for _, line := range lines {
+        if line = strings.TrimSpace(line); line != "" {
+            tail = tail + "\n    " + line
+        }
+    }
+    return tail
+}
+
+func logName(log *os.File) string {
+    if log == nil {
+        return ""
+    }
+    return log.Name()
+}
+
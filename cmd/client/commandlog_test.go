@@ -0,0 +1,58 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+var parseOnce sync.Once
+
+// Parses the default flags once for the tests that read the parameters.
+func testParameters() *Parameters {
+    parseOnce.Do(func() {
+        ParseFlags()
+    })
+    return GetParameters()
+}
+
+func TestCommandLogUnwritable(t *testing.T) {
+    testParameters()
+    tests := []struct {
+        description string
+        home        string
+    }{
+        // Files stand in for the directories so that they cannot be written to, even by root.
+        {"a file in place of the metadata directory", ".armchair"},
+        {"a file in place of the home directory", "home"},
+    }
+    for _, test := range tests {
+        dir := t.TempDir()
+        if err := os.WriteFile(filepath.Join(dir, test.home), nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+        home := dir
+        if test.home == "home" {
+            home = filepath.Join(dir, test.home)
+        }
+        t.Setenv("HOME", home)
+        done := make(chan *os.File)
+        go func() {
+            StartProgress("Title")
+            ProgressStep("Optimizing video")
+            done <- openCommandLog("ffmpeg", []string{"-version"}, 0)
+        }()
+        select {
+            case log := <-done:
+                StopProgress()
+                if log != nil {
+                    log.Close()
+                    t.Errorf("%s: opened the command log %s", test.description, log.Name())
+                }
+            case <-time.After(5 * time.Second):
+                t.Fatalf("%s: logging deadlocked", test.description)
+        }
+    }
+}
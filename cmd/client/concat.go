@@ -2,46 +2,56 @@ package main
 
 import (
     "io/ioutil"
-    "log"
     "path/filepath"
     "fmt"
-    "os/exec"
-    "os"
     "strconv"
     "strings"
 )
 
-func Concat(path string, title string) {
-    fmt.Printf("### Concatenating %s.\n", title)
+// Concat joins the " - ptN" videos of a title into a single movie. Returns a StepError when the
+// videos could not be joined.
+func Concat(path string, title string) error {
+    Infof("### Concatenating %s.", title)
     if GetParameters().DryRun() {
-        return
+        return nil
     }
-    tmpDir, _ := ioutil.TempDir(os.TempDir(), "optimize")
-    defer os.RemoveAll(tmpDir)
+    tmpDir := MkTmpDir()
+    defer RemoveTmp(tmpDir)
     videos := findAll(path, title)
-    if !scaleAll(videos) {
-        fmt.Println("Could not scale all videos.")
-        return
+    if len(videos) == 0 {
+        return stepFailed(title, "find the parts", fmt.Errorf("no parts found"))
     }
-    if !copyAll(videos, tmpDir) {
-        fmt.Println("Could not copy all videos.")
-        return
+    // The joined movie takes the owner and mode of the first part.
+    attributes, err := readAttributes(videos[0].Path())
+    if err != nil {
+        return stepFailed(title, "read the first part", err)
+    }
+    ProgressStep("Scaling videos")
+    if err := scaleAll(videos); err != nil {
+        return stepFailed(title, "scale videos", err)
+    }
+    if err := copyAll(videos, tmpDir); err != nil {
+        return stepFailed(title, "copy videos", err)
     }
-    if !sanitizeAll(videos) {
-        fmt.Println("Could not sanitize all videos.")
-        return
+    ProgressStep("Sanitizing videos")
+    if err := sanitizeAll(videos); err != nil {
+        return stepFailed(title, "sanitize videos", err)
     }
-    if !joinAll(videos, title, filepath.Join(tmpDir, "concat.mp4")) {
-        fmt.Println("Could not join all videos.")
-        return
+    ProgressStep("Joining videos")
+    if err := joinAll(videos, title, filepath.Join(tmpDir, "concat.mp4")); err != nil {
+        return stepFailed(title, "join videos", err)
     }
     moveAll(
         findAll(path, title),
         Mkdir(filepath.Join(filepath.Join(path, title), "orig")))
-    Move(
-        filepath.Join(tmpDir, "concat.mp4"),
-        filepath.Join(filepath.Join(path, title), title) + ".mp4")
-    GetParameters().Cleanup(filepath.Join(path, title))
+    joined := filepath.Join(filepath.Join(path, title), title) + ".mp4"
+    err = Move(filepath.Join(tmpDir, "concat.mp4"), joined)
+    if err != nil {
+        return stepFailed(title, "move the joined video", err)
+    }
+    inheritAttributes(attributes, joined)
+    GetParameters().Cleanup(filepath.Join(path, title), joined)
+    return nil
 }
 
 func findAll(path string, title string) []*Video {
@@ -49,8 +59,7 @@ func findAll(path string, title string) []*Video {
     for i := 0; i < 9000; i++ {
         files, err := ioutil.ReadDir(path + title)
         if err != nil {
-            log.Fatal(err)
-            continue
+            Fatalf("%v", err)
         }
         for _, file := range files {
             for _, extension := range VideoExtensions {
@@ -67,40 +76,40 @@ func findAll(path string, title string) []*Video {
     return videos
 }
 
-func scaleAll(videos []*Video) bool {
+func scaleAll(videos []*Video) error {
     for _, v := range videos {
-        if !scale(v) {
-            return false
+        if err := scale(v); err != nil {
+            return fmt.Errorf("%s: %v", v.Name(), err)
         }
     }
-    return true
+    return nil
 }
 
-func copyAll(videos []*Video, toDir string) bool {
+func copyAll(videos []*Video, toDir string) error {
     for i, v := range videos {
         toFilePath := filepath.Join(toDir, strconv.Itoa(i + 1000) + ".mp4")
         if (!Copy(v.Path(), filepath.Join(toDir, strconv.Itoa(i + 1000) + ".mp4"))) {
-            return false
+            return fmt.Errorf("unable to copy %s", v.Path())
         }
         v.SetPath(toFilePath)
     }
-    return true
+    return nil
 }
 
-func sanitizeAll(videos []*Video) bool {
+func sanitizeAll(videos []*Video) error {
     fps := videos[0].Fps()
     w, h, c, dar := max(videos)
     for _, v := range videos {
-        if !sanitize(v, fps, w, h, c, dar) {
-            return false
+        if err := sanitize(v, fps, w, h, c, dar); err != nil {
+            return fmt.Errorf("%s: %v", v.Name(), err)
         }        
     }
-    return true
+    return nil
 }
 
-func joinAll(videos []*Video, title string, to string) bool {
-    tmpFiles, _ := ioutil.TempDir(os.TempDir(), "join")
-    defer os.RemoveAll(tmpFiles)
+func joinAll(videos []*Video, title string, to string) error {
+    tmpFiles := MkTmpDir()
+    defer RemoveTmp(tmpFiles)
     Write(filepath.Join(tmpFiles, "files.txt"), files(videos))
     Write(filepath.Join(tmpFiles, "metadata.txt"), metadata(title, videos))
     params := []string{}
@@ -112,31 +121,30 @@ func joinAll(videos []*Video, title string, to string) bool {
     params = append(params, "-c", "copy")
     params = append(params, to)
     PrintFfmpeg(params)
-    err := exec.Command("ffmpeg", params...).Run()
-    if err != nil {
-        fmt.Println(err)
-        return false
+    duration := float64(0)
+    for _, v := range videos {
+        duration += v.Seconds()
     }
-    return true
+    return RunFfmpeg(params, duration, -1)
 }
 
 func moveAll(videos []*Video, toDir string) bool {
     for _, v := range videos {
         err := Move(v.Path(), toDir)
         if err != nil {
-            fmt.Println("Failed to move %v to %v: %v", v.Path(), toDir, err)
+            Errorf("Failed to move %v to %v: %v", v.Path(), toDir, err)
         }
     }
     return true
 }
 
-func scale(v *Video) bool {
+func scale(v *Video) error {
     if v.Width() > 959 && v.Height() > 547 {
-        return true
+        return nil
     }
-    fmt.Printf("Scale Video %s.\n", v.Name())
-    tmpDir, _ := ioutil.TempDir(os.TempDir(), "optimize-")
-    defer os.RemoveAll(tmpDir)
+    Infof("Scaling video %s.", v.Name())
+    tmpDir := MkTmpDir()
+    defer RemoveTmp(tmpDir)
     original := filepath.Join(tmpDir, "original.mp4")
     optimized := filepath.Join(tmpDir, "optimized.mp4")
     Copy(v.Path(), original)
@@ -159,27 +167,29 @@ func scale(v *Video) bool {
     params = append(params, "-y")
     params = append(params, optimized)
     PrintFfmpeg(params)
-    fmt.Println("Executing...")
-    err := exec.Command("ffmpeg", params...).Run()
+    err := RunFfmpeg(params, v.Seconds(), -1)
+    if err != nil {
+        return err
+    }
+    attributes, err := readAttributes(v.Path())
     if err != nil {
-        fmt.Println(err)
-        return false
+        return fmt.Errorf("unable to read the original: %v", err)
     }
     err = Move(v.Path(), v.Path() + ".orig")
     if (err != nil) {
-        return false
+        return fmt.Errorf("unable to keep the original: %v", err)
     }
     err = Move(optimized, v.Path())
     if (err != nil) {
         Move(v.Path() + ".orig", v.Path())
-        return false
+        return fmt.Errorf("unable to replace the original: %v", err)
     }
-    return true
+    inheritAttributes(attributes, v.Path())
+    return nil
 }
 
-func sanitize(v *Video, fps string, w int, h int, c int, dar float64) bool {
-    a := Audio{}
-    a.path = v.path
+func sanitize(v *Video, fps string, w int, h int, c int, dar float64) error {
+    duration := v.Seconds()
     vf := ""
     vf = vf + fmt.Sprintf("scale=(iw*sar)*min(%v/(iw*sar)\\,%v/ih):ih*min(%v/(iw*sar)\\,%v/ih):flags=print_info+spline+full_chroma_inp+full_chroma_int,",w, h, w, h)
     vf = vf + fmt.Sprintf("pad=%v:%v:(%v-iw*min(%v/iw\\,%v/ih))/2:(%v-ih*min(%v/iw\\,%v/ih))/2", w, h, w, w, h, w, w, h)
@@ -209,12 +219,7 @@ func sanitize(v *Video, fps string, w int, h int, c int, dar float64) bool {
     params = append(params, "-y")
     params = append(params, v.path)
     PrintFfmpeg(params)
-    err := exec.Command("ffmpeg", params...).Run()
-    if err != nil {
-        fmt.Println(err)
-        return false
-    }
-    return true
+    return RunFfmpeg(params, duration, -1)
 }
 
 func max(videos []*Video) (int, int, int, float64) {
@@ -250,7 +255,7 @@ func max(videos []*Video) (int, int, int, float64) {
 
 func metadata(title string, videos []*Video) string {
     chapterData := ";FFMETADATA1\n"
-    chapterData = chapterData + fmt.Sprintf("title=%s\n\n", title)
+    chapterData = chapterData + fmt.Sprintf("title=%s\n\n", escapeMetadata(title))
     start := 0
     end := 0
     for i, v := range videos {
@@ -259,7 +264,7 @@ func metadata(title string, videos []*Video) string {
         chapterData = chapterData + "TIMEBASE=1/1000\n"
         chapterData = chapterData + fmt.Sprintf("START=%v\n", start)
         chapterData = chapterData + fmt.Sprintf("END=%v\n", end)
-        chapterData = chapterData + fmt.Sprintf("title=CHAPTER %v: %s\n\n", i + 1, v.Name())
+        chapterData = chapterData + fmt.Sprintf("title=CHAPTER %v: %s\n\n", i + 1, escapeMetadata(v.Name()))
         start = end
     }
     // TrimSuffix will only trim the last match.
@@ -271,7 +276,12 @@ func metadata(title string, videos []*Video) string {
 func files(videos []*Video) string {
     files := ""
     for _, v := range videos {
-        files = files + fmt.Sprintf("file '%v'\n", v.Path())
+        files = files + ConcatEntry(v.Path())
     }
     return files
 }
+
+// Escapes the characters that are special in an ffmetadata file.
+func escapeMetadata(value string) string {
+    return escapeChars(value, "=;#\\\n")
+}
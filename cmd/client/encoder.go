@@ -0,0 +1,474 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+)
+
+var CodecValues string = " hevc avc av1 svtav1 vp9 "
+
+// Encoder holds the codec specific ffmpeg parameters used to encode a scene.
+type Encoder interface {
+    // Name returns the value of the codec flag that selects the encoder.
+    Name() string
+    // Codec returns the name of the ffmpeg encoder.
+    Codec() string
+    // PixelFormats returns the pixel formats the encoder accepts, 10-bit formats last.
+    PixelFormats() []string
+    // DefaultQuality returns the quality value used when no quality target is set.
+    DefaultQuality() int
+    // QualityStep returns the difference between quality values tried by the quality search.
+    QualityStep() int
+    // QualityArgs maps the quality value onto the encoder's quality knob.
+    QualityArgs(quality int) []string
+    // RateControlArgs caps the bitrate of the encoded scene.
+    RateControlArgs(maxBitrate int) []string
+    // PassArgs returns the parameters of a pass of a two-pass encode that keeps its statistics
+    // in files starting with stats. Returns nil when the encoder has no two-pass mode.
+    PassArgs(pass int, stats string) []string
+    // SpeedArgs sets the quality/encoding speed tradeoff for the preset.
+    SpeedArgs() []string
+    // GopArgs sets the max frames between keyframes.
+    GopArgs(gop string) []string
+    // ProfileArgs sets the profile and level of the encoded video.
+    ProfileArgs() []string
+    // TuneArgs adjusts the encoder's psychovisual and adaptive quantization settings to the
+    // tuning profile.
+    TuneArgs(tune string) []string
+    // GrainArgs returns the parameters that enable grain synthesis for the tuning profile.
+    // Videos are not denoised before encoding when the encoder synthesizes grain as it denoises
+    // the video itself.
+    GrainArgs(tune string) []string
+}
+
+// GetEncoder returns the encoder selected by the codec value. Unknown values return the HEVC
+// encoder.
+func GetEncoder(codec string) Encoder {
+    switch codec {
+    case "avc":
+        return &x264{}
+    case "av1":
+        return &libaom{}
+    case "svtav1":
+        return &svtav1{}
+    case "vp9":
+        return &vp9{}
+    default:
+        return &x265{}
+    }
+}
+
+// Accordig to https://goughlui.com/2016/08/27/video-compression-testing-x264-vs-x265-crf-in-handbrake-0-10-5/
+// there’s really no big difference between PSNRs for the average case between x264 and x265 on a
+// CRF value basis. A CRF of 16 is considered visually lossless for both.
+type x264 struct{}
+
+func (e *x264) Name() string {
+    return "avc"
+}
+
+func (e *x264) Codec() string {
+    return "libx264"
+}
+
+func (e *x264) PixelFormats() []string {
+    return []string{"yuv420p", "yuv420p10le"}
+}
+
+func (e *x264) DefaultQuality() int {
+    return 16
+}
+
+func (e *x264) QualityStep() int {
+    return 2
+}
+
+func (e *x264) QualityArgs(quality int) []string {
+    return []string{"-crf", strconv.Itoa(quality)}
+}
+
+func (e *x264) RateControlArgs(maxBitrate int) []string {
+    return cappedRate(maxBitrate)
+}
+
+func (e *x264) PassArgs(pass int, stats string) []string {
+    return []string{"-pass", strconv.Itoa(pass), "-passlogfile", stats}
+}
+
+func (e *x264) SpeedArgs() []string {
+    return []string{"-preset", GetParameters().Preset()}
+}
+
+func (e *x264) GopArgs(gop string) []string {
+    return []string{"-g", gop}
+}
+
+func (e *x264) ProfileArgs() []string {
+    profile := "high10"
+    if GetParameters().Force8Bit() {
+        profile = "high"
+    }
+    // Set the video level to 4.0 as its a good balance between compatability and quality.
+    return []string{"-profile:v", profile, "-level:v", "4.0"}
+}
+
+func (e *x264) TuneArgs(tune string) []string {
+    switch tune {
+    case "animation":
+        // Spend more bits on the dark and flat areas where animation shows banding.
+        return []string{"-tune", "animation", "-aq-mode", "3"}
+    case "film", "grain":
+        return []string{"-tune", tune}
+    default:
+        // The default psychovisual settings suit clean digital video.
+        return []string{}
+    }
+}
+
+func (e *x264) GrainArgs(tune string) []string {
+    return []string{}
+}
+
+type x265 struct{}
+
+func (e *x265) Name() string {
+    return "hevc"
+}
+
+func (e *x265) Codec() string {
+    return "libx265"
+}
+
+func (e *x265) PixelFormats() []string {
+    return []string{"yuv420p", "yuv420p10le"}
+}
+
+func (e *x265) DefaultQuality() int {
+    return 16
+}
+
+func (e *x265) QualityStep() int {
+    return 2
+}
+
+func (e *x265) QualityArgs(quality int) []string {
+    return []string{"-crf", strconv.Itoa(quality)}
+}
+
+func (e *x265) RateControlArgs(maxBitrate int) []string {
+    return cappedRate(maxBitrate)
+}
+
+func (e *x265) PassArgs(pass int, stats string) []string {
+    // x265 does not use the passlogfile parameter. The path is escaped as a title such as
+    // "Star Wars: A New Hope" would otherwise split the list of parameters.
+    return []string{"-x265-params", "pass=" + strconv.Itoa(pass) + ":stats=" + escapeChars(stats + ".log", `\':=`)}
+}
+
+func (e *x265) SpeedArgs() []string {
+    return []string{"-preset", GetParameters().Preset()}
+}
+
+func (e *x265) GopArgs(gop string) []string {
+    h265Params := []string{}
+    // Set the video level to 4.0 as its a good balance between compatability and quality.
+    // The level is set here because x265 only takes one list of parameters.
+    h265Params = append(h265Params, "level-idc=40")
+    h265Params = append(h265Params, "keyint=" + gop)
+    // These values need to be supplied to the x265 codec directly.
+    return []string{"-x265-params", strings.Join(h265Params, ":")}
+}
+
+func (e *x265) ProfileArgs() []string {
+    if GetParameters().Force8Bit() {
+        return []string{"-profile:v", "main"}
+    }
+    return []string{"-profile:v", "main10"}
+}
+
+func (e *x265) TuneArgs(tune string) []string {
+    switch tune {
+    case "animation":
+        return []string{"-tune", "animation", "-x265-params", "aq-mode=3"}
+    case "grain":
+        return []string{"-tune", "grain"}
+    case "film":
+        // x265 has no film tune; bias the adaptive quantization to dark scenes and keep detail.
+        return []string{"-x265-params", "aq-mode=3:psy-rd=2.0"}
+    default:
+        return []string{}
+    }
+}
+
+func (e *x265) GrainArgs(tune string) []string {
+    return []string{}
+}
+
+// https://brontosaurusrex.github.io/2021/06/05/AV1-encoding-for-dummies/
+type libaom struct{}
+
+func (e *libaom) Name() string {
+    return "av1"
+}
+
+func (e *libaom) Codec() string {
+    return "libaom-av1"
+}
+
+func (e *libaom) PixelFormats() []string {
+    return []string{"yuv420p", "yuv420p10le"}
+}
+
+// https://engineering.fb.com/2018/04/10/video-engineering/av1-beats-x264-and-libvpx-vp9-in-practical-use-case/
+// x264 CRF = {19, 23, 27, 31, 35, 39}, VP9/AV1 CRF/QP = {27, 33, 39, 45, 51, 57}
+// according to the above mapping, an h264 CRF of 16 == AV1 CRF of 22.5
+// Do not try for a better quality than 23; doing throw is wasting bits on diminishing returns as
+// a qmin of 23 for the AV1 codec is already considered visually lossless.
+func (e *libaom) DefaultQuality() int {
+    return 23
+}
+
+func (e *libaom) QualityStep() int {
+    return 4
+}
+
+func (e *libaom) QualityArgs(quality int) []string {
+    // Do not limit the worst case visual quality scenario as this will be capped by the max
+    // bitrate.
+    return []string{"-qmin", strconv.Itoa(quality), "-qmax", "63"}
+}
+
+func (e *libaom) RateControlArgs(maxBitrate int) []string {
+    // Set average bitrate to be 250kbps less than the max bitrate.
+    return append([]string{"-b:v", strconv.Itoa(maxBitrate - 250000)}, cappedRate(maxBitrate)...)
+}
+
+func (e *libaom) PassArgs(pass int, stats string) []string {
+    return []string{"-pass", strconv.Itoa(pass), "-passlogfile", stats}
+}
+
+func (e *libaom) SpeedArgs() []string {
+    params := []string{}
+    // Setting the lag-in-frames to 25 lets the AV1 codec look ahead 25 frames into the future.
+    // Higher values improve visual quality.
+    params = append(params, "-lag-in-frames", "25")
+    // Enable use of alternate reference frames.
+    params = append(params, "-auto-alt-ref", "1")
+    // Set the quality/encoding speed tradeoff. Valid range is from 0 to 8, higher numbers
+    // indicating greater speed and lower quality.
+    // * Presets of ultrafast to faster use a value of 7.
+    // * Presets of fast to slow use a value of 4.
+    // * presets of slower to placebo use a value of 1.
+    params = append(params, "-cpu-used", strconv.Itoa(7 - GetParameters().PresetGroup() * 3))
+    return params
+}
+
+func (e *libaom) GopArgs(gop string) []string {
+    return []string{"-g", gop}
+}
+
+func (e *libaom) ProfileArgs() []string {
+    return []string{}
+}
+
+func (e *libaom) TuneArgs(tune string) []string {
+    return []string{}
+}
+
+func (e *libaom) GrainArgs(tune string) []string {
+    // Setting the denoise-noise-level parameter enables grain synthesis. Animation and clean
+    // digital video have no grain to synthesize and film only has a little.
+    switch tune {
+    case "animation", "digital":
+        return []string{}
+    case "film":
+        return []string{"-denoise-noise-level", "25"}
+    default:
+        return []string{"-denoise-noise-level", "50"}
+    }
+}
+
+// SVT-AV1 is many times faster than libaom at a similar quality.
+// https://gitlab.com/AOMediaCodec/SVT-AV1/-/blob/master/Docs/Ffmpeg.md
+type svtav1 struct{}
+
+func (e *svtav1) Name() string {
+    return "svtav1"
+}
+
+func (e *svtav1) Codec() string {
+    return "libsvtav1"
+}
+
+func (e *svtav1) PixelFormats() []string {
+    return []string{"yuv420p", "yuv420p10le"}
+}
+
+func (e *svtav1) DefaultQuality() int {
+    return 23
+}
+
+func (e *svtav1) QualityStep() int {
+    return 4
+}
+
+func (e *svtav1) QualityArgs(quality int) []string {
+    return []string{"-crf", strconv.Itoa(quality)}
+}
+
+func (e *svtav1) RateControlArgs(maxBitrate int) []string {
+    // A max bitrate turns the CRF into a capped CRF.
+    return cappedRate(maxBitrate)
+}
+
+func (e *svtav1) PassArgs(pass int, stats string) []string {
+    // The ffmpeg wrapper of SVT-AV1 does not support two passes.
+    return nil
+}
+
+func (e *svtav1) SpeedArgs() []string {
+    // Valid range is from 0 to 13, higher numbers indicating greater speed and lower quality.
+    // * Presets of ultrafast to faster use a value of 10.
+    // * Presets of fast to slow use a value of 7.
+    // * presets of slower to placebo use a value of 4.
+    return []string{"-preset", strconv.Itoa(10 - GetParameters().PresetGroup() * 3)}
+}
+
+func (e *svtav1) GopArgs(gop string) []string {
+    return []string{"-g", gop}
+}
+
+func (e *svtav1) ProfileArgs() []string {
+    return []string{}
+}
+
+func (e *svtav1) TuneArgs(tune string) []string {
+    return []string{}
+}
+
+func (e *svtav1) GrainArgs(tune string) []string {
+    switch tune {
+    case "animation", "digital":
+        return []string{}
+    case "grain":
+        return []string{"-svtav1-params", "film-grain=12"}
+    default:
+        return []string{"-svtav1-params", "film-grain=8"}
+    }
+}
+
+// https://developers.google.com/media/vp9/settings/vod
+type vp9 struct{}
+
+func (e *vp9) Name() string {
+    return "vp9"
+}
+
+func (e *vp9) Codec() string {
+    return "libvpx-vp9"
+}
+
+func (e *vp9) PixelFormats() []string {
+    return []string{"yuv420p", "yuv420p10le"}
+}
+
+// Uses the same CRF to x264 mapping as AV1.
+func (e *vp9) DefaultQuality() int {
+    return 23
+}
+
+func (e *vp9) QualityStep() int {
+    return 4
+}
+
+func (e *vp9) QualityArgs(quality int) []string {
+    return []string{"-crf", strconv.Itoa(quality)}
+}
+
+func (e *vp9) RateControlArgs(maxBitrate int) []string {
+    // A CRF together with an average bitrate selects the constrained quality mode.
+    return append([]string{"-b:v", strconv.Itoa(maxBitrate - 250000)}, cappedRate(maxBitrate)...)
+}
+
+func (e *vp9) PassArgs(pass int, stats string) []string {
+    return []string{"-pass", strconv.Itoa(pass), "-passlogfile", stats}
+}
+
+func (e *vp9) SpeedArgs() []string {
+    params := []string{}
+    params = append(params, "-quality", "good")
+    // Valid range is from 0 to 5 for the good quality deadline, higher numbers indicating greater
+    // speed and lower quality.
+    // * Presets of ultrafast to faster use a value of 5.
+    // * Presets of fast to slow use a value of 3.
+    // * presets of slower to placebo use a value of 1.
+    params = append(params, "-cpu-used", strconv.Itoa(5 - GetParameters().PresetGroup() * 2))
+    params = append(params, "-lag-in-frames", "25")
+    params = append(params, "-auto-alt-ref", "1")
+    return params
+}
+
+func (e *vp9) GopArgs(gop string) []string {
+    return []string{"-g", gop}
+}
+
+func (e *vp9) ProfileArgs() []string {
+    // Profile 2 is needed for 10-bit color depth.
+    if GetParameters().Force8Bit() {
+        return []string{"-profile:v", "0"}
+    }
+    return []string{"-profile:v", "2"}
+}
+
+func (e *vp9) TuneArgs(tune string) []string {
+    if tune == "film" || tune == "grain" {
+        return []string{"-tune-content", "film"}
+    }
+    return []string{}
+}
+
+func (e *vp9) GrainArgs(tune string) []string {
+    return []string{}
+}
+
+// Returns the pixel format of the encoded video.
+func pixelFormat(e Encoder) string {
+    formats := e.PixelFormats()
+    if GetParameters().Force8Bit() {
+        return formats[0]
+    }
+    return formats[len(formats) - 1]
+}
+
+// Joins the values of codec parameter lists, such as -x265-params, that are supplied more than
+// once since ffmpeg only uses the last one.
+func mergeCodecParams(params []string) []string {
+    merged := []string{}
+    positions := make(map[string]int)
+    for i := 0; i < len(params); i++ {
+        if strings.HasSuffix(params[i], "-params") && i + 1 < len(params) {
+            if position, ok := positions[params[i]]; ok {
+                merged[position] = merged[position] + ":" + params[i + 1]
+            } else {
+                positions[params[i]] = len(merged) + 1
+                merged = append(merged, params[i], params[i + 1])
+            }
+            i++
+            continue
+        }
+        merged = append(merged, params[i])
+    }
+    return merged
+}
+
+func cappedRate(maxBitrate int) []string {
+    params := []string{}
+    // Setting the maxrate parameter caps to 50kbps less than the maximum bitrate.
+    // This gives us a little bit of wiggle room to stay under the cap without needing to do two passes.
+    params = append(params, "-maxrate", strconv.Itoa(maxBitrate - 50000))
+    // Cap max buffer size to twice the max bitrate.
+    // Having a buffer allows us to increase our quality as we are able to pre-load the buffer
+    // with additional quality whenever the bitrate is not maxed.
+    params = append(params, "-bufsize", strconv.Itoa(maxBitrate * 2))
+    return params
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrInterrupted is returned by a title that stopped because of an interrupt. The title is
+// resumed by the next run, so it is not a failure.
+var ErrInterrupted = errors.New("interrupted")
+
+// StepError is the failure of one step of a title, such as optimizing its video. It wraps the
+// error that caused it.
+type StepError struct {
+    Title string
+    Step  string
+    Err   error
+}
+
+func (e *StepError) Error() string {
+    return fmt.Sprintf("failed to %s: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+    return e.Err
+}
+
+// Logs the failure of the title's step and returns it as a StepError. Interrupts are returned
+// as they are.
+func stepFailed(title string, step string, err error) error {
+    if errors.Is(err, ErrInterrupted) {
+        return err
+    }
+    failure := &StepError{title, step, err}
+    Errorf("Failed to %s: %v", step, err)
+    return failure
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Pass untracked as the chunk for commands whose progress should not be reported.
+const untracked = -2
+
+// RunFfmpeg runs ffmpeg with a machine-readable progress pipe and reports the progress to the
+// current title. The duration is the length in seconds of the video being written and is used
+// to work out the percent complete. When chunk is not negative the progress is reported against
+// that chunk of the title; otherwise it is reported against the title's current step. ffmpeg is
+// killed when it reports no progress for longer than the stall timeout, such as when it hangs
+// reading from a network mount that went away.
+func RunFfmpeg(params []string, duration float64, chunk int) error {
+    args := append([]string{"-nostats", "-progress", "pipe:1"}, params...)
+    cmd := command(jobContext(), "ffmpeg", args...)
+    stderr := &tailBuffer{}
+    cmd.Stderr = stderr
+    log := openCommandLog("ffmpeg", args, chunk)
+    if log != nil {
+        defer log.Close()
+        cmd.Stderr = io.MultiWriter(stderr, log)
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    if err = cmd.Start(); err != nil {
+        return err
+    }
+    var progress io.Reader = stdout
+    stall := GetParameters().StallTimeout()
+    stalled := atomic.Bool{}
+    if stall > 0 {
+        watchdog := time.AfterFunc(stall, func() {
+            stalled.Store(true)
+            kill(cmd)
+        })
+        defer watchdog.Stop()
+        progress = &resetReader{stdout, watchdog, stall}
+    }
+    // ffmpeg writes a block of key=value lines that ends with progress=continue or progress=end.
+    fps := float64(0)
+    speed := float64(0)
+    seconds := float64(0)
+    scanner := bufio.NewScanner(progress)
+    for scanner.Scan() {
+        pair := strings.SplitN(scanner.Text(), "=", 2)
+        if len(pair) != 2 {
+            continue
+        }
+        value := strings.TrimSpace(pair[1])
+        switch pair[0] {
+        case "fps":
+            fps, _ = strconv.ParseFloat(value, 64)
+        case "speed":
+            speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+        case "out_time_us":
+            if us, err := strconv.ParseFloat(value, 64); err == nil && us > 0 {
+                seconds = us / 1000000
+            }
+        case "progress":
+            if chunk == untracked {
+                continue
+            }
+            percent := float64(100)
+            if value != "end" {
+                percent = 0
+                if duration > 0 {
+                    percent = 100 * seconds / duration
+                }
+                if percent > 99.9 {
+                    percent = 99.9
+                }
+            }
+            if chunk < 0 {
+                eta := float64(0)
+                if speed > 0 && value != "end" {
+                    eta = (duration - seconds) / speed
+                }
+                StepProgress(percent, fps, speed, eta)
+            } else {
+                ChunkProgressUpdate(chunk, percent, fps, speed)
+            }
+        }
+    }
+    err = cmd.Wait()
+    if stalled.Load() {
+        return fmt.Errorf("ffmpeg stalled: no progress for %v%s", stall, logTail(stderr.Bytes()))
+    }
+    return commandError("ffmpeg", err, stderr.Bytes(), logName(log))
+}
+
+// Resets the timer whenever something is read.
+type resetReader struct {
+    io.Reader
+    timer *time.Timer
+    delay time.Duration
+}
+
+func (r *resetReader) Read(p []byte) (int, error) {
+    n, err := r.Reader.Read(p)
+    if n > 0 {
+        r.timer.Reset(r.delay)
+    }
+    return n, err
+}
+
+// RunCommand runs the program with the arguments, without a shell, and returns what it wrote to
+// stdout and stderr. When the program fails the error includes the last line it wrote to stderr.
+// The program is killed when it runs for longer than the command timeout.
+func RunCommand(name string, args ...string) ([]byte, []byte, error) {
+    ctx, cancel := jobContext(), context.CancelFunc(func() {})
+    if timeout := GetParameters().CommandTimeout(); timeout > 0 {
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+    }
+    defer cancel()
+    cmd := command(ctx, name, args...)
+    // Stop waiting for the output once the program has been killed.
+    cmd.WaitDelay = time.Second
+    stdout := &bytes.Buffer{}
+    stderr := &bytes.Buffer{}
+    cmd.Stdout = stdout
+    cmd.Stderr = stderr
+    log := openCommandLog(name, args, untracked)
+    if log != nil {
+        defer log.Close()
+        cmd.Stderr = io.MultiWriter(stderr, log)
+    }
+    err := cmd.Run()
+    if ctx.Err() == context.DeadlineExceeded {
+        return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("%s timed out after %v", name, GetParameters().CommandTimeout())
+    }
+    return stdout.Bytes(), stderr.Bytes(), commandError(name, err, stderr.Bytes(), logName(log))
+}
+
+// Ffmpeg runs ffmpeg with the arguments and returns what it wrote to stdout and stderr.
+func Ffmpeg(args ...string) ([]byte, []byte, error) {
+    return RunCommand("ffmpeg", append([]string{"-nostdin", "-hide_banner"}, args...)...)
+}
+
+// Ffprobe runs ffprobe with the arguments and returns what it wrote to stdout.
+func Ffprobe(args ...string) ([]byte, error) {
+    stdout, _, err := RunCommand("ffprobe", append([]string{"-v", "error"}, args...)...)
+    return stdout, err
+}
+
+// ProbeEntry returns the value of the entry, such as stream=width, of the first stream of the
+// file that matches the stream specifier, such as v:0. Returns an empty string when the file
+// could not be probed.
+func ProbeEntry(path string, stream string, entry string) string {
+    stdout, err := Ffprobe(
+        "-select_streams", stream,
+        "-of", "default=noprint_wrappers=1:nokey=1",
+        "-show_entries", entry,
+        path)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(strings.Split(string(stdout), "\n")[0])
+}
+
+// ConcatEntry returns the line of an ffconcat list for the file. Single quotes in the path are
+// closed, escaped and reopened so that the concat demuxer reads the path as is.
+func ConcatEntry(path string) string {
+    return "file '" + strings.ReplaceAll(path, "'", `'\''`) + "'\n"
+}
+
+// Returns the error of a failed command followed by the end of its stderr and where its log is.
+func commandError(name string, err error, stderr []byte, log string) error {
+    if err == nil {
+        return nil
+    }
+    if log != "" {
+        return fmt.Errorf("%s failed: %v (log: %s)%s", name, err, log, logTail(stderr))
+    }
+    return fmt.Errorf("%s failed: %v%s", name, err, logTail(stderr))
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// MakeMKV names its outputs after the disc followed by the title number, ie: title_t00.mkv
+var makemkvPattern = regexp.MustCompile(`(?i)_t(\d+)$`)
+var yearPattern = regexp.MustCompile(`^(19|20)\d\d$`)
+var resolutionPattern = regexp.MustCompile(`(?i)^\d{3,4}[pi]$`)
+var partPattern = regexp.MustCompile(`(?i)^(pt|cd|disc|disk|part)(\d{1,2})$`)
+var unsafePattern = regexp.MustCompile(`[\\/:*?"<>|]`)
+var ReleaseTags string = " 4k uhd bluray blu-ray bdrip brrip dvdrip dvdscr webrip web-dl webdl web hdtv hdrip remux x264 x265 h264 h265 hevc avc xvid divx aac ac3 dts 10bit hdr proper repack extended unrated remastered limited "
+// Words that mark a file as a sample or an extra rather than the movie, ie: "Movie.2010-sample.mkv"
+var ExtraTags string = " sample trailer teaser featurette featurettes extra extras bonus behindthescenes deletedscenes "
+
+type ingestFile struct {
+    path  string
+    ext   string
+    title string
+    part  int
+    track int
+    size  int64
+}
+
+// Ingest moves loose video files found in the supplied directory into Title/Title.ext folders
+// so that GetMedia can find them. Multi-part sets are kept together using the " - ptN" naming.
+// Samples and extras are left where they are.
+func Ingest(path string) {
+    Infof("### Ingesting %s.", path)
+    groups := make(map[string][]*ingestFile)
+    titles := make([]string, 0)
+    for _, file := range ingestable(path) {
+        if file.title == "" {
+            Warnf("Unable to determine a title for: %s", file.path)
+            continue
+        }
+        if _, ok := groups[file.title]; !ok {
+            titles = append(titles, file.title)
+        }
+        groups[file.title] = append(groups[file.title], file)
+    }
+    for _, title := range titles {
+        ingestTitle(path, title, groups[title])
+    }
+}
+
+func ingestTitle(path string, title string, files []*ingestFile) {
+    files, left := ingestSelect(files)
+    for _, file := range left {
+        Warnf("Leaving %s; it is not clearly a part of %s.", file.path, title)
+    }
+    dir := filepath.Join(path, title)
+    if GetMedia(path, title) != nil || hasParts(dir) {
+        Infof("Skipping %s; the title already exists.", title)
+        return
+    }
+    targets := make([]string, len(files))
+    for i, file := range files {
+        if len(files) == 1 && file.part == 0 {
+            targets[i] = filepath.Join(dir, title + "." + file.ext)
+        } else {
+            targets[i] = filepath.Join(dir, fmt.Sprintf("%s - pt%v.%s", title, i + 1, file.ext))
+        }
+        if targets[i] != file.path && PathExists(targets[i]) {
+            Infof("Skipping %s; %s already exists.", title, targets[i])
+            return
+        }
+    }
+    for i, file := range files {
+        Infof("Ingesting %s as %s.", file.path, targets[i])
+    }
+    if GetParameters().DryRun() {
+        return
+    }
+    if Mkdir(dir) == "" {
+        return
+    }
+    for i, file := range files {
+        if file.path == targets[i] {
+            continue
+        }
+        err := Move(file.path, targets[i])
+        if err != nil {
+            Errorf("Failed to move %s to %s: %v", file.path, targets[i], err)
+            continue
+        }
+        // Only removes the directory the file came from when it is left empty.
+        if from := filepath.Dir(file.path); from != filepath.Clean(path) && from != dir {
+            os.Remove(from)
+        }
+    }
+}
+
+// Splits the files that resolve to the same title into the ones that are ingested and the ones
+// that are left where they are. Files are only joined when they are clearly parts (ie: CD1, pt2,
+// disc1). Otherwise the largest file is taken to be the movie, since the others are likely the
+// extra titles MakeMKV rips from a disc.
+func ingestSelect(files []*ingestFile) ([]*ingestFile, []*ingestFile) {
+    sort.SliceStable(files, func(i, j int) bool {
+        if files[i].part != files[j].part {
+            return files[i].part > files[j].part
+        }
+        if files[i].size != files[j].size {
+            return files[i].size > files[j].size
+        }
+        if files[i].track != files[j].track {
+            return files[i].track < files[j].track
+        }
+        return files[i].path < files[j].path
+    })
+    selected := make([]*ingestFile, 0)
+    left := make([]*ingestFile, 0)
+    for _, file := range files {
+        switch {
+        case file.part == 0 && len(selected) > 0:
+            left = append(left, file)
+        case file.part > 0 && len(selected) > 0 && selected[len(selected) - 1].part == file.part:
+            // Only the largest file of each part is kept.
+            left = append(left, file)
+        default:
+            selected = append(selected, file)
+        }
+    }
+    sort.SliceStable(selected, func(i, j int) bool {
+        return selected[i].part < selected[j].part
+    })
+    return selected, left
+}
+
+// Finds the video files in the root of the supplied directory, as well as the video files inside
+// subdirectories that are not already titles. Files inside subdirectories are named after the
+// subdirectory since rippers and release groups tend to name the folder rather than the file.
+func ingestable(path string) []*ingestFile {
+    found := make([]*ingestFile, 0)
+    entries, err := ioutil.ReadDir(path)
+    if err != nil {
+        Errorf("%v", err)
+        return found
+    }
+    for _, entry := range entries {
+        if strings.HasPrefix(entry.Name(), ".") {
+            continue
+        }
+        if !entry.IsDir() {
+            if file := parseIngestFile(filepath.Join(path, entry.Name()), ""); file != nil {
+                found = append(found, file)
+            }
+            continue
+        }
+        if isExtra(entry.Name()) {
+            Infof("Skipping %s; it looks like a sample or an extra.", filepath.Join(path, entry.Name()))
+            continue
+        }
+        dir := filepath.Join(path, entry.Name())
+        if GetMedia(path, entry.Name()) != nil || hasParts(dir) {
+            continue
+        }
+        files, err := ioutil.ReadDir(dir)
+        if err != nil {
+            Errorf("%v", err)
+            continue
+        }
+        for _, file := range files {
+            if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+                continue
+            }
+            if parsed := parseIngestFile(filepath.Join(dir, file.Name()), entry.Name()); parsed != nil {
+                found = append(found, parsed)
+            }
+        }
+    }
+    return found
+}
+
+func parseIngestFile(path string, dirName string) *ingestFile {
+    ext := strings.TrimPrefix(filepath.Ext(path), ".")
+    if !isVideoExtension(ext) {
+        return nil
+    }
+    if isExtra(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))) {
+        Infof("Skipping %s; it looks like a sample or an extra.", path)
+        return nil
+    }
+    file := &ingestFile{}
+    file.path = path
+    file.ext = strings.ToLower(ext)
+    if info, err := os.Stat(path); err == nil {
+        file.size = info.Size()
+    }
+    file.title, file.part, file.track = CleanTitle(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+    if dirName != "" {
+        if title, _, _ := CleanTitle(dirName); title != "" {
+            file.title = title
+        }
+    }
+    return file
+}
+
+// CleanTitle converts a release-style name such as "The.Matrix.1999.1080p.BluRay.x264" or a
+// MakeMKV output name such as "THE_MATRIX_t00" into a "Title (Year)" value. The part number
+// (ie: CD2, pt2) and MakeMKV title number are returned when present; otherwise they are zero.
+func CleanTitle(name string) (string, int, int) {
+    track := 0
+    if match := makemkvPattern.FindStringSubmatch(name); match != nil {
+        track, _ = strconv.Atoi(match[1])
+        track = track + 1
+        name = strings.TrimSuffix(name, match[0])
+    }
+    name = strings.ReplaceAll(name, "_", " ")
+    if !strings.Contains(name, " ") {
+        name = strings.ReplaceAll(name, ".", " ")
+    }
+    words := strings.Fields(name)
+    for i, word := range words {
+        words[i] = strings.Trim(word, "[]()")
+    }
+    // The title is everything before the last year; "2001 A Space Odyssey 1968" keeps its leading year.
+    year := -1
+    for i := len(words) - 1; i > 0; i-- {
+        if yearPattern.MatchString(words[i]) {
+            year = i
+            break
+        }
+    }
+    cut := len(words)
+    if year > 0 {
+        cut = year
+    } else {
+        for i := 1; i < len(words); i++ {
+            if isReleaseTag(words[i]) {
+                cut = i
+                break
+            }
+        }
+    }
+    // Part markers are only trusted after the year or at the very end of the name, since
+    // "Part 1" is often part of the title itself.
+    part := 0
+    partFrom := cut
+    if year < 0 && len(words) - 2 < cut {
+        partFrom = len(words) - 2
+    }
+    for i := partFrom; i < len(words); i++ {
+        if i < 1 {
+            continue
+        }
+        number := ""
+        if match := partPattern.FindStringSubmatch(words[i]); match != nil {
+            if year < 0 && strings.EqualFold(match[1], "part") {
+                continue
+            }
+            number = match[2]
+        } else if i + 1 < len(words) && partPattern.MatchString(words[i] + words[i + 1]) {
+            if year < 0 && strings.EqualFold(words[i], "part") {
+                continue
+            }
+            number = words[i + 1]
+        } else {
+            continue
+        }
+        part, _ = strconv.Atoi(number)
+        if i < cut {
+            cut = i
+        }
+        break
+    }
+    title := strings.Join(words[:cut], " ")
+    title = unsafePattern.ReplaceAllString(title, "")
+    title = strings.TrimSpace(strings.Trim(title, " -"))
+    if strings.ToUpper(title) == title && strings.ToLower(title) != title {
+        title = capitalize(title)
+    }
+    if title == "" || strings.EqualFold(title, "title") {
+        return "", part, track
+    }
+    if year > 0 {
+        title = fmt.Sprintf("%s (%s)", title, words[year])
+    }
+    return title, part, track
+}
+
+func isReleaseTag(word string) bool {
+    word = strings.ToLower(word)
+    return resolutionPattern.MatchString(word) || strings.Contains(ReleaseTags, " " + word + " ")
+}
+
+// Returns true when the name marks a sample or an extra. The first word is not checked, so that
+// titles such as "Trailer Park Boys" are not mistaken for one, unless it is the only word.
+func isExtra(name string) bool {
+    words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+        return strings.ContainsRune(" ._-[]()", r)
+    })
+    for i, word := range words {
+        if i > 0 || len(words) == 1 {
+            if strings.Contains(ExtraTags, " " + word + " ") {
+                return true
+            }
+            if i + 2 < len(words) && strings.Contains(ExtraTags, " " + word + words[i + 1] + words[i + 2] + " ") {
+                return true
+            }
+            if i + 1 < len(words) && strings.Contains(ExtraTags, " " + word + words[i + 1] + " ") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func isVideoExtension(ext string) bool {
+    for _, extension := range VideoExtensions {
+        if strings.EqualFold(ext, extension) {
+            return true
+        }
+    }
+    return false
+}
+
+func capitalize(title string) string {
+    words := strings.Fields(strings.ToLower(title))
+    for i, word := range words {
+        words[i] = strings.ToUpper(word[:1]) + word[1:]
+    }
+    return strings.Join(words, " ")
+}
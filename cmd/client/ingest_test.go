@@ -0,0 +1,121 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestCleanTitle(t *testing.T) {
+    tests := []struct {
+        name  string
+        title string
+        part  int
+        track int
+    }{
+        {"The.Matrix.1999.1080p.BluRay.x264", "The Matrix (1999)", 0, 0},
+        {"The Matrix (1999)", "The Matrix (1999)", 0, 0},
+        {"[The Matrix] (1999) [1080p]", "The Matrix (1999)", 0, 0},
+        {"2001.A.Space.Odyssey.1968.1080p", "2001 A Space Odyssey (1968)", 0, 0},
+        {"1917.2019.2160p.UHD.BluRay", "1917 (2019)", 0, 0},
+        {"Alien.1979.Directors.Cut.1080p", "Alien (1979)", 0, 0},
+        {"Back.to.the.Future.Part.II.1989.720p", "Back to the Future Part II (1989)", 0, 0},
+        {"Jaws.1080p.BluRay", "Jaws", 0, 0},
+        {"Arrival", "Arrival", 0, 0},
+        {"Se7en 1995", "Se7en (1995)", 0, 0},
+        {"Mission: Impossible 1996", "Mission Impossible (1996)", 0, 0},
+        {"THE_MATRIX_t00", "The Matrix", 0, 1},
+        {"THE_MATRIX_t12", "The Matrix", 0, 13},
+        {"title_t03", "", 0, 4},
+        {"Movie.2010.CD2", "Movie (2010)", 2, 0},
+        {"Movie.2010.cd1.XviD", "Movie (2010)", 1, 0},
+        {"Movie 2010 Part 2", "Movie (2010)", 2, 0},
+        {"Some.Movie.pt2", "Some Movie", 2, 0},
+        {"Some Movie Disc 1", "Some Movie", 1, 0},
+        {"Harry Potter and the Deathly Hallows Part 1", "Harry Potter and the Deathly Hallows Part 1", 0, 0},
+        {"Harry.Potter.and.the.Deathly.Hallows.Part.1.2010.1080p", "Harry Potter and the Deathly Hallows Part 1 (2010)", 0, 0},
+    }
+    for _, test := range tests {
+        title, part, track := CleanTitle(test.name)
+        if title != test.title || part != test.part || track != test.track {
+            t.Errorf("CleanTitle(%q) = %q, %v, %v; want %q, %v, %v", test.name, title, part, track, test.title, test.part, test.track)
+        }
+    }
+}
+
+func TestIsExtra(t *testing.T) {
+    tests := []struct {
+        name  string
+        extra bool
+    }{
+        {"sample", true},
+        {"Sample", true},
+        {"Movie.2010-sample", true},
+        {"Movie.2010.1080p.Sample", true},
+        {"Movie.2010.Trailer", true},
+        {"Movie Behind The Scenes", true},
+        {"Movie.Deleted.Scenes", true},
+        {"Featurettes", true},
+        {"The.Matrix.1999.1080p", false},
+        {"Trailer.Park.Boys.2006", false},
+        {"Extra.Ordinary.2019", false},
+        {"The.Interview.2014", false},
+    }
+    for _, test := range tests {
+        if extra := isExtra(test.name); extra != test.extra {
+            t.Errorf("isExtra(%q) = %v; want %v", test.name, extra, test.extra)
+        }
+    }
+}
+
+func TestIngestSelect(t *testing.T) {
+    tests := []struct {
+        description string
+        files       []*ingestFile
+        selected    []string
+    }{
+        {
+            "a single movie",
+            []*ingestFile{{path: "movie.mkv", size: 10}},
+            []string{"movie.mkv"},
+        },
+        {
+            "parts in order",
+            []*ingestFile{{path: "cd2.avi", part: 2, size: 5}, {path: "cd1.avi", part: 1, size: 6}},
+            []string{"cd1.avi", "cd2.avi"},
+        },
+        {
+            "parts without the files that are not parts",
+            []*ingestFile{{path: "cd1.avi", part: 1, size: 5}, {path: "bonus.avi", size: 50}, {path: "cd2.avi", part: 2, size: 5}},
+            []string{"cd1.avi", "cd2.avi"},
+        },
+        {
+            "the largest of each part",
+            []*ingestFile{{path: "cd1.avi", part: 1, size: 5}, {path: "cd1.mkv", part: 1, size: 9}},
+            []string{"cd1.mkv"},
+        },
+        {
+            "the largest MakeMKV title",
+            []*ingestFile{{path: "t00.mkv", track: 1, size: 2}, {path: "t01.mkv", track: 2, size: 30}, {path: "t02.mkv", track: 3, size: 1}},
+            []string{"t01.mkv"},
+        },
+    }
+    for _, test := range tests {
+        selected, left := ingestSelect(test.files)
+        if len(selected) + len(left) != len(test.files) {
+            t.Errorf("%s: %v files selected and %v left; want %v in total", test.description, len(selected), len(left), len(test.files))
+        }
+        paths := make([]string, 0)
+        for _, file := range selected {
+            paths = append(paths, file.path)
+        }
+        if len(paths) != len(test.selected) {
+            t.Errorf("%s: selected %v; want %v", test.description, paths, test.selected)
+            continue
+        }
+        for i := range paths {
+            if paths[i] != test.selected[i] {
+                t.Errorf("%s: selected %v; want %v", test.description, paths, test.selected)
+                break
+            }
+        }
+    }
+}
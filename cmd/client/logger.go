@@ -0,0 +1,182 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+)
+
+var LogLevelValues string = " debug info warn error "
+var LogFormatValues string = " text json "
+
+type LogLevel int
+
+const (
+    LevelDebug LogLevel = iota
+    LevelInfo
+    LevelWarn
+    LevelError
+)
+
+// Pass noChunk as the chunk of lines that are not about a chunk.
+const noChunk = -1
+
+func (l LogLevel) String() string {
+    return strings.Fields(strings.ToUpper(LogLevelValues))[l]
+}
+
+func parseLogLevel(level string) LogLevel {
+    for i, name := range strings.Fields(LogLevelValues) {
+        if name == level {
+            return LogLevel(i)
+        }
+    }
+    return LevelInfo
+}
+
+func Debugf(format string, a ...interface{}) {
+    Logf(LevelDebug, noChunk, format, a...)
+}
+
+func Infof(format string, a ...interface{}) {
+    Logf(LevelInfo, noChunk, format, a...)
+}
+
+func Warnf(format string, a ...interface{}) {
+    Logf(LevelWarn, noChunk, format, a...)
+}
+
+func Errorf(format string, a ...interface{}) {
+    Logf(LevelError, noChunk, format, a...)
+}
+
+// Fatalf logs the error and exits.
+func Fatalf(format string, a ...interface{}) {
+    Logf(LevelError, noChunk, format, a...)
+    os.Exit(1)
+}
+
+// Logf writes a line to the console and to the log file of the title being processed. Each line
+// carries the title and, when it is about a chunk, the chunk. Lines of info and above are also
+// added to the title's progress log.
+func Logf(level LogLevel, chunk int, format string, a ...interface{}) {
+    if level < GetParameters().LogLevel() {
+        return
+    }
+    message := fmt.Sprintf(format, a...)
+    now := time.Now()
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    title := ""
+    if progress != nil {
+        title = progress.Title
+    }
+    line := formatLine(now, level, title, chunk, message)
+    os.Stdout.WriteString(line)
+    if progress == nil {
+        return
+    }
+    progress.writeLog(line)
+    if level >= LevelInfo {
+        if chunk != noChunk {
+            message = fmt.Sprintf("scene %v: %s", chunk, message)
+        }
+        progress.Log = append(progress.Log, now.Format("15:04:05") + " " + message)
+        if len(progress.Log) > progressLogLines {
+            progress.Log = progress.Log[len(progress.Log) - progressLogLines:]
+        }
+    }
+}
+
+func formatLine(now time.Time, level LogLevel, title string, chunk int, message string) string {
+    if GetParameters().LogFormat() == "json" {
+        line := struct {
+            Time    string `json:"time"`
+            Level   string `json:"level"`
+            Title   string `json:"title,omitempty"`
+            Chunk   *int   `json:"chunk,omitempty"`
+            Message string `json:"msg"`
+        }{now.Format(time.RFC3339), level.String(), title, nil, message}
+        if chunk != noChunk {
+            line.Chunk = &chunk
+        }
+        data, _ := json.Marshal(line)
+        return string(data) + "\n"
+    }
+    context := ""
+    if title != "" && chunk != noChunk {
+        context = fmt.Sprintf("[%s scene %v] ", title, chunk)
+    } else if title != "" {
+        context = fmt.Sprintf("[%s] ", title)
+    }
+    return fmt.Sprintf("%s %-5s %s%s\n", now.Format("2006-01-02 15:04:05"), level.String(), context, message)
+}
+
+// Writes the line to the log file of the title's run. The file is only made once the title
+// reaches its first step so that titles that are only checked do not get one; until then the
+// lines are held back. Callers must hold the lock, so failures are written straight to the
+// console rather than logged.
+func (p *Progress) writeLog(line string) {
+    if GetParameters().KeepLogs() < 1 {
+        return
+    }
+    if p.logFile == nil && p.Step != "" && !p.logFailed {
+        if p.logPath == "" {
+            p.logFailed = true
+            p.heldLines = nil
+            return
+        }
+        file, err := os.OpenFile(p.logPath, os.O_CREATE | os.O_APPEND | os.O_WRONLY, 0644)
+        if err != nil {
+            os.Stdout.WriteString(fmt.Sprintf("Failed to open the log file %s: %v\n", p.logPath, err))
+            p.logFailed = true
+            p.heldLines = nil
+            return
+        }
+        p.logFile = file
+        pruneTitleLogs(filepath.Dir(p.logPath), p.Title, GetParameters().KeepLogs())
+        file.WriteString(strings.Join(p.heldLines, ""))
+        p.heldLines = nil
+    }
+    if p.logFile == nil {
+        if !p.logFailed {
+            p.heldLines = append(p.heldLines, line)
+        }
+        return
+    }
+    p.logFile.WriteString(line)
+}
+
+// Returns the log file of the title's run, which is kept next to the title's work directory.
+// Returns an empty string when the metadata directory cannot be made.
+func titleLogPath(title string, started time.Time) string {
+    work := workDir(title)
+    if work == "" {
+        return ""
+    }
+    return work + "." + started.Format("20060102-150405") + ".log"
+}
+
+// Removes all but the newest log files of the title from the directory.
+func pruneTitleLogs(dir string, title string, keep int) {
+    pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(title) + `\.\d{8}-\d{6}\.log$`)
+    files, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return
+    }
+    logs := make([]string, 0)
+    // ReadDir sorts by name, so the oldest logs come first.
+    for _, file := range files {
+        if pattern.MatchString(file.Name()) {
+            logs = append(logs, file.Name())
+        }
+    }
+    for i := 0; i < len(logs) - keep; i++ {
+        os.Remove(filepath.Join(dir, logs[i]))
+    }
+}
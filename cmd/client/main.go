@@ -2,7 +2,7 @@ package main
 
 import (
     "io/ioutil"
-    "log"
+    "os"
     "strings"
 )
 
@@ -12,54 +12,145 @@ func main() {
     if (!params.Valid()) {
         return
     }
-    for _, title := range multipart(params.InputDir()) {
-        Concat(params.InputDir(), title)
+    HandleSignals()
+    if !validValue(" jobs cancel retry move ", params.Mode()) {
+        for _, root := range params.Roots() {
+            PruneTrash(root)
+        }
+    }
+    switch params.Mode() {
+    case "daemon":
+        Daemon(false)
+        return
+    case "watch":
+        Daemon(true)
+        return
+    case "jobs":
+        PrintJobs()
+        return
+    case "cancel", "retry", "move":
+        UpdateJob(params.Mode(), params.Job())
+        return
+    }
+    // When a daemon is running this is only a thin client that adds titles to its queue.
+    remote := params.Mode() == "optimize" && DaemonRunning()
+    summary := &Summary{}
+    for _, root := range params.Roots() {
+        if Interrupted() {
+            break
+        }
+        switch params.Mode() {
+        case "ingest":
+            Ingest(root)
+        case "trash":
+            PrintTrash(root)
+        case "purge":
+            PurgeTrash(root)
+        case "restore":
+            Restore(root, summary)
+        default:
+            optimizeRoot(root, remote, summary)
+        }
+    }
+    summary.Print()
+    if Interrupted() {
+        os.Exit(130)
     }
-    for _, movie := range movies(params.InputDir()) {
-        Optimize(movie)
+    if summary.Failed() {
+        os.Exit(1)
     }
 }
 
+// Concatenates and then optimizes the titles in the library root, recording each result in the
+// summary. Stops when interrupted.
+func optimizeRoot(root string, remote bool, summary *Summary) {
+    for _, title := range multipart(root) {
+        if Interrupted() {
+            return
+        }
+        if remote {
+            enqueue("concat", root, title)
+            continue
+        }
+        sizeBefore := titleSize(root, title)
+        StartProgress(title)
+        err := Concat(root, title)
+        StopProgress()
+        summary.Add(title, "concatenated", true, err, sizeBefore, titleSize(root, title))
+    }
+    for _, movie := range movies(root) {
+        if Interrupted() {
+            return
+        }
+        if remote {
+            enqueue("optimize", root, movie.Name())
+            continue
+        }
+        sizeBefore := titleSize(root, movie.Name())
+        StartProgress(movie.Name())
+        changed, err := Optimize(movie)
+        StopProgress()
+        summary.Add(movie.Name(), "optimized", changed, err, sizeBefore, titleSize(root, movie.Name()))
+    }
+}
+
+// Adds the title to the daemon's queue when it matches the filter.
+func enqueue(kind string, root string, title string) {
+    if !GetParameters().Matches(title) {
+        return
+    }
+    job, err := EnqueueRemote(kind, root, title)
+    if err != nil {
+        Errorf("Failed to queue %s: %v", title, err)
+        return
+    }
+    Infof("### Queued %s as job %v (%s).", title, job.Id, job.Status)
+}
+
 func multipart(path string) []string {
     movies := make([]string, 0)
     files, err := ioutil.ReadDir(path)
     if err != nil {
-        log.Fatal(err)
-        return movies
+        Fatalf("%v", err)
     }
     for _, file := range files {
-        if !file.IsDir() {
+        if !file.IsDir() || strings.HasPrefix(file.Name(), ".") {
             continue;
         }
         if GetMedia(path, file.Name()) != nil {
             continue
         }
-        files0, err := ioutil.ReadDir(path + file.Name())
-        if err != nil {
-            log.Fatal(err)
-            continue
+        if hasParts(path + file.Name()) {
+            movies = append(movies, file.Name())
         }
-        out:
-        for _, file0 := range files0 {
-            for _, extension := range VideoExtensions {
-                if strings.HasSuffix(file0.Name(), "." + extension) && strings.Contains(file0.Name(), " - pt") {
-                    movies = append(movies, file.Name())
-                    break out
-                }
+    }
+    return movies
+}
+
+// Returns true when the directory contains videos that end with " - ptN".
+func hasParts(path string) bool {
+    files, err := ioutil.ReadDir(path)
+    if err != nil {
+        return false
+    }
+    for _, file := range files {
+        for _, extension := range VideoExtensions {
+            if strings.HasSuffix(file.Name(), "." + extension) && strings.Contains(file.Name(), " - pt") {
+                return true
             }
         }
     }
-    return movies
+    return false
 }
 
 func movies(path string) []*Media {
 	movies := make([]*Media, 0)
     files, err := ioutil.ReadDir(path)
     if err != nil {
-        log.Fatal(err)
+        Fatalf("%v", err)
     }
     for _, file := range files {
-    	if !file.IsDir() {
+    	if !file.IsDir() || strings.HasPrefix(file.Name(), ".") {
 	    	continue;
 	    }
 	    movie := GetMedia(path, file.Name())
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// ChunkRecord records the state of a chunk of a title across runs.
+type ChunkRecord struct {
+    Chunk    int     `json:"chunk"`
+    Start    float64 `json:"start"`
+    End      float64 `json:"end"`
+    Settings string  `json:"settings"`
+    Status   string  `json:"status"`
+    Attempts int     `json:"attempts"`
+    Checksum string  `json:"checksum"`
+    Error    string  `json:"error,omitempty"`
+}
+
+// Manifest is the list of chunks of a title, kept in manifest.json in the title's work directory
+// under the metadata directory. A chunk encoded by an earlier run is only reused when it was
+// encoded with the same settings and its file still has the recorded checksum.
+type Manifest struct {
+    path   string
+    lock   sync.Mutex
+    chunks map[int]*ChunkRecord
+}
+
+func LoadManifest(path string) *Manifest {
+    manifest := &Manifest{}
+    manifest.path = filepath.Join(path, "manifest.json")
+    manifest.chunks = make(map[int]*ChunkRecord)
+    data, err := os.ReadFile(manifest.path)
+    if err != nil {
+        return manifest
+    }
+    records := make([]*ChunkRecord, 0)
+    if err = json.Unmarshal(data, &records); err != nil {
+        Warnf("Ignoring the unreadable chunk manifest %s: %v", manifest.path, err)
+        return manifest
+    }
+    for _, record := range records {
+        manifest.chunks[record.Chunk] = record
+    }
+    return manifest
+}
+
+// Reusable returns true when the chunk was encoded with the settings and the output still has
+// the recorded checksum.
+func (m *Manifest) Reusable(chunk int, settings string, output string) bool {
+    m.lock.Lock()
+    record, ok := m.chunks[chunk]
+    m.lock.Unlock()
+    if !ok || record.Status != "done" || record.Settings != settings || !PathExists(output) {
+        return false
+    }
+    checksum, err := fileChecksum(output)
+    return err == nil && checksum == record.Checksum
+}
+
+// Started records an attempt at encoding the chunk and returns the number of attempts so far.
+func (m *Manifest) Started(chunk int, segment *Segment, settings string) int {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    record, ok := m.chunks[chunk]
+    if !ok || record.Settings != settings {
+        record = &ChunkRecord{Chunk: chunk}
+        m.chunks[chunk] = record
+    }
+    record.Start = segment.Start
+    record.End = segment.End
+    record.Settings = settings
+    record.Status = "running"
+    record.Attempts++
+    record.Checksum = ""
+    record.Error = ""
+    m.save()
+    return record.Attempts
+}
+
+// Finished records the outcome of an attempt. The checksum of the output is recorded when the
+// attempt succeeded.
+func (m *Manifest) Finished(chunk int, output string, encodeErr error) error {
+    checksum := ""
+    if encodeErr == nil {
+        var err error
+        if checksum, err = fileChecksum(output); err != nil {
+            encodeErr = err
+        }
+    }
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    record := m.chunks[chunk]
+    if encodeErr != nil {
+        record.Status = "failed"
+        record.Error = encodeErr.Error()
+    } else {
+        record.Status = "done"
+        record.Checksum = checksum
+    }
+    m.save()
+    return encodeErr
+}
+
+// Callers must hold the lock.
+func (m *Manifest) save() {
+    records := make([]*ChunkRecord, 0)
+    for _, record := range m.chunks {
+        records = append(records, record)
+    }
+    sort.Slice(records, func(i, j int) bool {
+        return records[i].Chunk < records[j].Chunk
+    })
+    data, _ := json.MarshalIndent(records, "", "  ")
+    // Write to a temporary file first so that an interrupted write does not lose the manifest.
+    if Write(m.path + ".tmp", string(data)) {
+        os.Rename(m.path + ".tmp", m.path)
+    }
+}
+
+// Returns a hash of the ffmpeg parameters and boundaries used to encode a chunk.
+func settingsHash(segment *Segment, params []string) string {
+    hash := sha256.Sum256([]byte(fmt.Sprintf("%v|%v|%s", segment.Start, segment.End, strings.Join(params, "\x00"))))
+    return hex.EncodeToString(hash[:])
+}
+
+func fileChecksum(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -2,15 +2,15 @@ package main
 
 import (
     "os"
-    "fmt"
     "strings"
 )
 
 type Media struct {
-    name     string
-    path     string
-    video    *Video
-    audio    *Audio
+    name      string
+    path      string
+    extension string
+    video     *Video
+    audio     *Audio
 }
 
 func GetMedia(path string, title string) *Media {
@@ -27,6 +27,7 @@ func GetMedia(path string, title string) *Media {
             m := Media{}
             m.name = title
             m.path = path + title + "/"
+            m.extension = extension
             m.video = &Video{}
             m.video.path = absolute
             return &m
@@ -43,6 +44,12 @@ func (m *Media) Path() string {
     return m.path
 }
 
+// Extension returns the extension of the title's video when it was found, which is kept when
+// the video is replaced by an optimized mp4.
+func (m *Media) Extension() string {
+    return m.extension
+}
+
 func (m *Media) Video() *Video {
     return m.video
 }
@@ -99,26 +106,26 @@ func (m *Media) Optimized() bool {
 }
 
 func (m *Media) Println() {
-    fmt.Println("* Media", m.name)
-    fmt.Println("  - name:", m.name)
-    fmt.Println("  - path:", m.path)
-    fmt.Println("  - optimized:", m.Optimized())
-    fmt.Println("  * Video")
-    fmt.Println("    - width:", m.Video().Width())
-    fmt.Println("    - height:", m.Video().Height())
-    fmt.Println("    - pix fmt:", m.Video().PixFmt())
-    fmt.Println("    - color primaries:", m.Video().ColorPrimaries())
-    fmt.Println("    - bitrate:", m.Video().Bitrate())
-    fmt.Println("    - dar:", m.Video().Dar())
-    fmt.Println("    - sar:", m.Video().Sar())
-    fmt.Println("    * Crop")
-    fmt.Println("      - filter:", m.Video().Crop().Filter())
-    fmt.Println("      - width:", m.Video().Crop().Width())
-    fmt.Println("      - height:", m.Video().Crop().Height())
-    fmt.Println("    - duration:", m.Video().Duration())
-    fmt.Println("    - fps:", m.Video().Fps())
-//        fmt.Println("    - progressive:", m.Video().Progressive())
-    fmt.Println("  * Audio")
-    fmt.Println("    - channels:", m.Audio().Channels())
-    fmt.Println("    - bitrate:", m.Audio().Bitrate())    
+    Debugf("* Media %v", m.name)
+    Debugf("  - name: %v", m.name)
+    Debugf("  - path: %v", m.path)
+    Debugf("  - optimized: %v", m.Optimized())
+    Debugf("  * Video")
+    Debugf("    - width: %v", m.Video().Width())
+    Debugf("    - height: %v", m.Video().Height())
+    Debugf("    - pix fmt: %v", m.Video().PixFmt())
+    Debugf("    - color primaries: %v", m.Video().ColorPrimaries())
+    Debugf("    - bitrate: %v", m.Video().Bitrate())
+    Debugf("    - dar: %v", m.Video().Dar())
+    Debugf("    - sar: %v", m.Video().Sar())
+    Debugf("    * Crop")
+    Debugf("      - filter: %v", m.Video().Crop().Filter())
+    Debugf("      - width: %v", m.Video().Crop().Width())
+    Debugf("      - height: %v", m.Video().Crop().Height())
+    Debugf("    - duration: %v", m.Video().Duration())
+    Debugf("    - fps: %v", m.Video().Fps())
+//        Debugf("    - progressive: %v", m.Video().Progressive())
+    Debugf("  * Audio")
+    Debugf("    - channels: %v", m.Audio().Channels())
+    Debugf("    - bitrate: %v", m.Audio().Bitrate())
 }
@@ -1,52 +1,67 @@
 package main
 
 import (
-    "io/ioutil"
+    "sync"
     "path/filepath"
     "fmt"
-    "os/exec"
     "os"
     "strconv"
-    "strings"
+    "time"
 )
 
-func Optimize(m *Media) {
+var twoPassWarning sync.Once
+
+// The delay before a failed scene is retried the first time. It doubles with each retry.
+const retryDelay = 10 * time.Second
+
+// Optimize re-encodes the media when needed. Returns false when the media had already been
+// optimized, and a StepError when a step failed.
+func Optimize(m *Media) (bool, error) {
     if m.Optimized() {
-        fmt.Printf("### %s has already been optimized.\n", m.Name())
-        return
+        Infof("### %s has already been optimized.", m.Name())
+        // Originals kept for review are discarded once the review file has been deleted.
+        if !GetParameters().DryRun() && len(findOriginals(m.Path())) > 0 {
+            GetParameters().Cleanup(m.Path(), m.Video().Path())
+        }
+        return false, nil
     }
-    fmt.Printf("### Optimizing %s.\n", m.Name())
+    Infof("### Optimizing %s.", m.Name())
     if GetParameters().DryRun() {
-        return
+        if !m.OptimizedVideo() {
+            printChunkPlan(m)
+        }
+        return true, nil
     }
     if !m.OptimizedVideo() {
-        if (!optimizeVideo(m)) {
-            fmt.Println("Failed to optimize video.")
-            return
+        if err := optimizeVideo(m); err != nil {
+            return false, stepFailed(m.Name(), "optimize video", err)
         }
     }
+    if Interrupted() {
+        return true, ErrInterrupted
+    }
     _, err := os.Stat(m.Path() + "original_audio.mka")
     if err != nil {
-        err2 := backupAudio(m)
-        if err2 != nil {
-            fmt.Printf("Failed to backup audio: %v\n", err2)
-            return
+        if err = backupAudio(m); err != nil {
+            return true, stepFailed(m.Name(), "back up audio", err)
         }
     }
     if !m.OptimizedAudio() {
-        if (!optimizeAudio(m)) {
-            fmt.Println("Failed to optimize audio.")
+        // The originals are kept when the audio fails so that the next run can try again.
+        if err = optimizeAudio(m); err != nil {
+            return true, stepFailed(m.Name(), "optimize audio", err)
         }
     }
-    GetParameters().Cleanup(m.Path())
+    GetParameters().Cleanup(m.Path(), m.Path() + m.Name() + ".mp4")
+    return true, nil
 }
 
 func backupAudio(m *Media) error {
-    fmt.Println("Backup Audio:")
-    tmpDir, err := ioutil.TempDir(os.TempDir(), "optimize-")
-    defer os.RemoveAll(tmpDir)
-    if err != nil {
-        return err
+    ProgressStep("Backing up audio")
+    tmpDir := MkTmpDir()
+    defer RemoveTmp(tmpDir)
+    if tmpDir == "" {
+        return fmt.Errorf("unable to make a temporary directory")
     }
     original := filepath.Join(tmpDir, "original.mp4")
     backup := filepath.Join(tmpDir, "backup.mka")
@@ -58,21 +73,19 @@ func backupAudio(m *Media) error {
     params = append(params, "-y")
     params = append(params, backup)
     PrintFfmpeg(params)
-    fmt.Println("Executing...")
-    err = exec.Command("ffmpeg", params...).Run()
+    err := RunFfmpeg(params, m.Video().Seconds(), -1)
     if err != nil {
         return err
     }
     return Move(backup, m.Path() + "original_audio.mka")
 }
 
-func optimizeAudio(m *Media) bool {
-    fmt.Println("Optimize Audio:")
-    tmpDir, err := ioutil.TempDir(os.TempDir(), "optimize-")
-    defer os.RemoveAll(tmpDir)
-    if err != nil {
-        fmt.Println(err)
-        return false
+func optimizeAudio(m *Media) error {
+    ProgressStep("Optimizing audio")
+    tmpDir := MkTmpDir()
+    defer RemoveTmp(tmpDir)
+    if tmpDir == "" {
+        return fmt.Errorf("unable to make a temporary directory")
     }
     vOriginal := filepath.Join(tmpDir, "original.mp4")
     aOriginal := filepath.Join(tmpDir, "original.mka")
@@ -94,127 +107,166 @@ func optimizeAudio(m *Media) bool {
     params = append(params, "-y")
     params = append(params, optimized)
     PrintFfmpeg(params)
-    fmt.Println("Executing...")
-    err = exec.Command("ffmpeg", params...).Run()
+    err := RunFfmpeg(params, m.Video().Seconds(), -1)
     if err != nil {
-        fmt.Println(err)
-        return false
+        return err
     }
-    err = Move(m.Video().Path(), m.Video().Path() + ".audio.orig") 
+    attributes, err := readAttributes(m.Video().Path())
     if (err != nil) {
-        return false
+        return fmt.Errorf("unable to read the original: %v", err)
+    }
+    archived, err := archiveOriginal(m, "audio")
+    if (err != nil) {
+        return fmt.Errorf("unable to keep the original: %v", err)
     }
     err = Move(optimized, m.Path() + m.Name() + ".mp4")
     if (err != nil) {
-        Move(m.Video().Path() + ".audio.orig", m.Video().Path())
-        return false
+        Move(archived, m.Video().Path())
+        return fmt.Errorf("unable to replace the original: %v", err)
     }
-    return true
+    inheritAttributes(attributes, m.Path() + m.Name() + ".mp4")
+    return nil
 }
 
-func optimizeVideo(m *Media) bool {
-    fmt.Println("Optimizing", m.Name())
-    path := filepath.Join(DefaultMetadataDir(), m.Name())
+func optimizeVideo(m *Media) error {
+    ProgressStep("Optimizing video")
+    path := workDir(m.Name())
+    if path == "" {
+        return fmt.Errorf("unable to make the work directory")
+    }
     if (!PathExists(path)) {
         Mkdir(path)
     }
-    defer os.RemoveAll(path)
     original := &Video{}
     original.SetPath(filepath.Join(path, "original.mp4"))
     if !PathExists(original.Path()) {
-        Copy(m.Video().Path(), original.Path())
+        // Copy to a temporary file first so that an interrupted copy is not mistaken for the
+        // original when the title is resumed.
+        tmp := TrackTmp(original.Path() + ".tmp")
+        if !Copy(m.Video().Path(), tmp) || os.Rename(tmp, original.Path()) != nil {
+            RemoveTmp(tmp)
+            return fmt.Errorf("unable to copy %s to the work directory", m.Video().Path())
+        }
+        RemoveTmp(tmp)
     }
     optimized := filepath.Join(path, "optimized.mp4")
     original.DetectCrop()
+    original.Tune()
+    segments, err := segmentVideo(path, original)
+    if err != nil {
+        return fmt.Errorf("unable to split scenes: %v", err)
+    }
+    encoded := optimizeScenes(path, original, segments, m.MaxVideoBitrate())
+    if Interrupted() {
+        Warnf("Interrupted. The optimized scenes are kept in %s and reused by the next run.", path)
+        return ErrInterrupted
+    }
+    ProgressStep("Verifying scenes")
+    if err = verifyScenes(original, segments, encoded); err != nil {
+        return fmt.Errorf("unable to verify scenes: %v", err)
+    }
     scenes := ""
-    for _, scene := range optimizeScenes(path, original, m.MaxVideoBitrate()) {
-        scenes = scenes + fmt.Sprintf("file '%v'\n", scene.Path())
+    for _, scene := range encoded {
+        scenes = scenes + ConcatEntry(scene.Path())
     }
-    tmpFiles, _ := ioutil.TempDir(os.TempDir(), GetBrand())
-    defer os.RemoveAll(tmpFiles)
+    tmpFiles := MkTmpDir()
+    defer RemoveTmp(tmpFiles)
     Write(filepath.Join(tmpFiles, "scenes.txt"), scenes)
+    ProgressStep("Joining scenes")
     params := []string{}
     params = append(params, "-f", "concat")
     params = append(params, "-safe", "0")
     params = append(params, "-i", filepath.Join(tmpFiles, "scenes.txt"))
     params = append(params, "-c", "copy")
     params = append(params, optimized)
-    // PrintFfmpeg(params)
-    // fmt.Println("Executing...")
-    err := exec.Command("ffmpeg", params...).Run()
+    err = RunFfmpeg(params, original.Seconds(), -1)
     if err != nil {
-        fmt.Println(err)
-        return false
+        return fmt.Errorf("unable to join scenes: %v", err)
     }
-    err = Move(m.Video().Path(), m.Video().Path() + ".orig")
+    if err = verifyVideo(original, segments, optimized); err != nil {
+        return fmt.Errorf("unable to verify the optimized video: %v", err)
+    }
+    review, flagged := "", false
+    if GetParameters().Review() {
+        review, flagged = reviewQuality(m, segments, original, optimized)
+    }
+    attributes, err := readAttributes(m.Video().Path())
     if (err != nil) {
-        return false
+        return fmt.Errorf("unable to read the original: %v", err)
+    }
+    archived, err := archiveOriginal(m, "video")
+    if (err != nil) {
+        return fmt.Errorf("unable to keep the original: %v", err)
     }
     err = Move(optimized, m.Path() + m.Name() + ".mp4")
     if (err != nil) {
-        Move(m.Video().Path() + ".orig", m.Video().Path())
-        return false
+        Move(archived, m.Video().Path())
+        return fmt.Errorf("unable to replace the original: %v", err)
+    }
+    inheritAttributes(attributes, m.Path() + m.Name() + ".mp4")
+    if GetParameters().QualitySearch() {
+        keepQuality(m, path)
+    }
+    if flagged && Write(filepath.Join(m.Path(), ReviewFile), review) {
+        applyDefaultAttributes(filepath.Join(m.Path(), ReviewFile))
     }
     m.Video().SetPath(m.Path() + m.Name() + ".mp4")
     m.Audio().SetPath(m.Path() + m.Name() + ".mp4")
-    return true
+    // The work directory is kept when the title fails so that the next run can resume it.
+    os.RemoveAll(path)
+    return nil
 }
 
-func detectScenes(target string, v *Video) {
-    fmt.Println("Detecting Scenes...")
-    detectScenes := []string{}
-    detectScenes = append(detectScenes, "ffprobe")
-    detectScenes = append(detectScenes, "-show_frames")
-    detectScenes = append(detectScenes, "-show_entries", "frame=best_effort_timestamp_time")
-    detectScenes = append(detectScenes, "-of", "compact=p=0")
-    detectScenes = append(detectScenes, "-f", "lavfi", fmt.Sprintf(`"movie=%s,select=gt(scene\,0.5)"`, v.Path()))
-    detectScenes = append(detectScenes, ">", fmt.Sprintf(`"%s"`, target))
-    // fmt.Println(strings.Join(detectScenes, " "))
-    err := exec.Command("bash","-c",strings.Join(detectScenes, " ")).Run()
-    if err != nil {
-        fmt.Println(err)
+func optimizeScenes(path string, v *Video, segments []*Segment, maxBitrate int) []*Video {
+    cores := GetParameters().Cores()
+    for i, segment := range segments {
+        Logf(LevelDebug, i, "The scene spans from %v to %v seconds.", segment.Start, segment.End)
+        AddChunk(i, segment.Start, segment.End)
     }
-}
-
-func optimizeScenes(path string, v *Video, maxBitrate int) []*Video {
-    detectedScenes := filepath.Join(path, "scenes.txt")
-    if !PathExists(detectedScenes) {
-        detectScenes(detectedScenes, v)
+    // Each scene is capped at a bitrate, and the two-pass encodes aim for a target bitrate 10%
+    // below the cap so that the scene does not overshoot.
+    caps := make([]int, len(segments))
+    targets := make([]int, len(segments))
+    for i := range caps {
+        caps[i] = maxBitrate
+        targets[i] = maxBitrate - maxBitrate / 10
     }
-    cores := GetParameters().Cores()
-    minDuration := float64(300)
-    if thisDuration, _ := strconv.ParseFloat(v.Duration(), 64); thisDuration / float64(cores) < minDuration {
-        minDuration = thisDuration / float64(cores)
-    }
-    prevTime := float64(0)
-    detectedTimes := make([]string, 0)
-    for _, detectedScene := range Read(detectedScenes) {
-        meta := strings.Split(detectedScene, "|")
-        time := strings.TrimPrefix(meta[0], "best_effort_timestamp_time=")
-        score := strings.TrimPrefix(meta[1], "tag:lavfi.scene_score=")
-        if thisTime, _ := strconv.ParseFloat(time, 64); thisTime - prevTime > minDuration {
-            detectedTimes = append(detectedTimes, time)
-            fmt.Println("scene", len(detectedTimes), time, score)
-            prevTime = thisTime
+    if GetParameters().Budget() {
+        // The two-pass encodes aim for the scene's share of the budget and are only capped at the
+        // peak, while the scenes encoded at a constant quality are capped at their share.
+        targets = sceneBitrates(path, v, segments, maxBitrate)
+        for i := range caps {
+            caps[i] = targets[i]
+            if twoPass() {
+                caps[i] = scenePeak(maxBitrate)
+            }
         }
     }
-    detectedTimes = append(detectedTimes, v.Duration())
-    fmt.Println("scene", len(detectedTimes), v.Duration(), "1.000000")
+    ProgressStep("Optimizing scenes")
+    manifest := LoadManifest(path)
     // Create a bounded channel, limit that channel to 5 cores.
     // source: https://medium.com/@deckarep/gos-extended-concurrency-semaphores-part-1-5eeabfa351ce
     var sem = make(chan int, cores)
-    start := "0"
     scenes := make([]*Video, 0)
-    for i, end := range detectedTimes {
+    for i, segment := range segments {
         scene := Video{}
         scene.SetPath(v.Path() + ".pt" + strconv.Itoa(i))
         scenes = append(scenes, &scene)
         sem <- 1
-        go func(v *Video, start string, end string, i int) {
-            optimizeScene(v, maxBitrate, start, end, i)
+        // Once interrupted, let the running scenes finish but start no more.
+        if Interrupted() {
             <-sem
-        }(v, start, end, i)
-        start = end
+            break
+        }
+        go func(v *Video, segment *Segment, i int) {
+            ChunkStatus(i, "running")
+            if optimizeScene(v, segment, targets[i], caps[i], i, manifest) {
+                ChunkStatus(i, "done")
+            } else {
+                ChunkStatus(i, "failed")
+            }
+            <-sem
+        }(v, segment, i)
     }
     // Fill the bounded channel up which forces us to block until all threads have finished.
     for i := 0; i < cores; i++ {
@@ -227,106 +279,159 @@ func optimizeScenes(path string, v *Video, maxBitrate int) []*Video {
     return scenes
 }
 
-func optimizeScene(v *Video, maxBitrate int, start string, end string, count int) {
-    if PathExists(v.Path() + ".pt" + strconv.Itoa(count)) {
-        return
-    }
-    fmt.Println("Optimizing scene: ", start, end, count)
-    startEstimate, _ := strconv.ParseFloat(start, 64)
-    startEstimate = startEstimate - float64(3)
+// Encodes the segment of the video. The video supplies the filters and frame rate, which are
+// worked out for the whole video rather than for each segment. A chunk encoded by an earlier run
+// is reused when the manifest shows that it is intact, and failed attempts are retried with an
+// increasing delay. Two-pass encodes aim for the target bitrate. Returns false when ffmpeg failed
+// to encode the scene.
+func optimizeScene(v *Video, segment *Segment, target int, maxBitrate int, count int, manifest *Manifest) bool {
+    output := v.Path() + ".pt" + strconv.Itoa(count)
+    tmp := v.Path() + ".tmp.pt" + strconv.Itoa(count)
+    stats := filepath.Join(filepath.Dir(v.Path()), "pass.pt" + strconv.Itoa(count))
     params := []string{}
-    // https://stackoverflow.com/questions/21420296/how-to-extract-time-accurate-video-segments-with-ffmpeg
-    // Just supplying the -ss and -to parameters before the input value to enable keyframe seeking 
-    // should be fince since we are obtaining the times to split on by finding keyframes with large
-    // amounts change from the previous frame.
-    params = append(params, "-ss", start)
-    params = append(params, "-to", end)
-    params = append(params, "-i", v.Path())
-    params = append(params, "-map", "0:v:0")
-    if GetParameters().ForceAv1() {
-        // https://brontosaurusrex.github.io/2021/06/05/AV1-encoding-for-dummies/
-        // Setting the denoise-noise-level parameter enables grain synthesis.
-        // This needs to be set before the video filter because the video filter does scaling 
-        // and we want to denoise the video before scaling the video.
-        params = append(params, "-denoise-noise-level", "50")
-    }
-    // Add video filters such as scaling, denoising, and deinterlacing.
-    params = append(params, "-vf", v.Filter(true))
-    // Setting vsync to 1 forces a constant frame rate.
-    params = append(params, "-vsync", "1")
-    // Limit threads here; we are using parallel processing of multiple scenes instead of 
-    // ffmpeg's native multithreading. This is needed because a lot of the video filters 
-    // are slow because they are not multi-threaded.
-    params = append(params, "-threads", "1")
-    params = append(params, "-vcodec", GetParameters().VideoCodec())
-    params = append(params, "-r", v.Fps())
-    if GetParameters().ForceAv1() {
-        // Setting the lag-in-frames to 25 lets the AV1 codec look ahead 25 frames into the future.
-        // Higher values improve visual quality.
-        params = append(params, "-lag-in-frames", "25")
-        // Enable use of alternate reference frames.
-        params = append(params, "-auto-alt-ref", "1")
-        // https://engineering.fb.com/2018/04/10/video-engineering/av1-beats-x264-and-libvpx-vp9-in-practical-use-case/
-        // x264 CRF = {19, 23, 27, 31, 35, 39}, VP9/AV1 CRF/QP = {27, 33, 39, 45, 51, 57}
-        // according to the above mapping, an h264 CRF of 16 == AV1 CRF of 22.5
-        // Do not try for a better quality than 23; doing throw is wasting bits on diminishing 
-        // returns as a qmin of 23 for the AV1 codec is already considered visually lossless.
-        params = append(params, "-qmin", "23")
-        // Do not limit the worst case visual quality scenario as this will be capped by the max 
-        // bitrate.
-        params = append(params, "-qmax", "63")
-        // Set the quality/encoding speed tradeoff. Valid range is from 0 to 8, higher numbers 
-        // indicating greater speed and lower quality.
-        // * Presets of ultrafast to faster use a value of 7.
-        // * Presets of fast to slow use a value of 4.
-        // * presets of slower to placebo use a value of 1.
-        params = append(params, "-cpu-used", strconv.Itoa(7 - GetParameters().PresetGroup() * 3))
-        // Set the max frames between keyframes
-        params = append(params, "-g", GetParameters().GOP())
-        // Set average bitrate to be 250kbps less than the max bitrate.
-        params = append(params, "-b:v", strconv.Itoa(maxBitrate - 250000))
+    params = append(params, "-i", segment.Path)
+    if twoPass() {
+        params = append(params, twoPassParams(v, target, maxBitrate, 2, stats)...)
     } else {
-        // Accordig to https://goughlui.com/2016/08/27/video-compression-testing-x264-vs-x265-crf-in-handbrake-0-10-5/
-        // there’s really no big difference between PSNRs for the average case between x264 and x265 on a CRF value basis.
-        params = append(params, "-crf", "16")
-        params = append(params, "-preset", GetParameters().Preset())
-        params = append(params, "-profile:v", GetParameters().VideoProfile())
-        if GetParameters().ForceAvc() {
-            // Set the video level to 4.0 as its a good balance between compatability and quality.
-            params = append(params, "-level:v", "4.0")
-            // Set the max frames between keyframes.
-            params = append(params, "-g", GetParameters().GOP())
-        } else if GetParameters().ForceHevc() {
-            h265Params := []string{}
-            // Set the video level to 4.0 as its a good balance between compatability and quality.
-            h265Params = append(h265Params, "level-idc=40")
-            // Set the max frames between keyframes.
-            h265Params = append(h265Params, "keyint=" + GetParameters().GOP())
-            // These values need to be supplied to the x265 codec directly.
-            params = append(params, "-x265-params", strings.Join(h265Params, ":"))
+        quality := GetParameters().Encoder().DefaultQuality()
+        if GetParameters().QualitySearch() {
+            quality = searchQuality(v, segment, maxBitrate, count)
         }
+        ChunkQuality(count, quality)
+        params = append(params, videoParams(v, maxBitrate, quality)...)
     }
-    // Setting the maxrate parameter caps to 50kbps less than the maximum bitrate.
-    // This gives us a little bit of wiggle room to stay under the cap without needing to do two passes.
-    params = append(params, "-maxrate", strconv.Itoa(maxBitrate - 50000))
-    // Cap max buffer size to twice the max bitrate.
-    // Having a buffer allows us to increase our quality as we are able to pre-load the buffer
-    // with additional quality whenever the bitrate is not maxed.
-    params = append(params, "-bufsize", strconv.Itoa(maxBitrate * 2))
     params = append(params, "-map", "0:a")
     params = append(params, "-c:a", "copy")
     params = append(params, "-movflags", "+faststart")
     params = append(params, "-f", "mp4")
     params = append(params, "-y")
-    params = append(params, v.Path() + ".tmp.pt" + strconv.Itoa(count))
-    //PrintFfmpeg(params)
-    //fmt.Println("Executing...")
-    err := exec.Command("ffmpeg", params...).Run()
-    if err != nil {
-        fmt.Println(err)
+    params = append(params, tmp)
+    settings := settingsHash(segment, params)
+    if manifest.Reusable(count, settings, output) {
+        Logf(LevelInfo, count, "Reusing the optimized scene.")
+        return true
+    }
+    os.Remove(output)
+    TrackTmp(tmp)
+    defer RemoveTmp(tmp)
+    for tries := 1; ; tries++ {
+        attempt := manifest.Started(count, segment, settings)
+        Logf(LevelInfo, count, "Optimizing the scene from %v to %v seconds (attempt %v).", segment.Start, segment.End, attempt)
+        var err error
+        if twoPass() {
+            err = firstPass(v, segment, target, maxBitrate, count, stats, settings)
+        }
+        if err == nil {
+            err = RunFfmpeg(params, segment.Seconds(), count)
+        }
+        if err == nil {
+            err = Move(tmp, output)
+        }
+        if err = manifest.Finished(count, output, err); err == nil {
+            return true
+        }
+        // Keep the failed scene out of the joined video.
+        Logf(LevelError, count, "Failed to optimize the scene: %v", err)
+        os.Remove(tmp)
+        os.Remove(output)
+        // A cancelled job is not retried, as its commands fail straight away.
+        if tries > GetParameters().Retries() || Interrupted() || jobContext().Err() != nil {
+            return false
+        }
+        delay := retryDelay * time.Duration(1 << (tries - 1))
+        Logf(LevelWarn, count, "Retrying the scene in %v.", delay)
+        ChunkStatus(count, "retrying")
+        if !Sleep(delay) {
+            return false
+        }
+        ChunkStatus(count, "running")
+    }
+}
+
+// Returns true when scenes should be encoded in two passes. Encoders without a two-pass mode
+// fall back to a constant quality.
+func twoPass() bool {
+    if !GetParameters().TwoPass() {
+        return false
+    }
+    if GetParameters().Encoder().PassArgs(1, "") == nil {
+        twoPassWarning.Do(func() {
+            Warnf("The %s codec has no two-pass mode; encoding at a constant quality instead.", GetParameters().Encoder().Name())
+        })
+        return false
+    }
+    return true
+}
+
+// Runs the analysis pass of a two-pass encode of the scene. The pass is skipped when its
+// statistics were already written for the same settings by an interrupted run.
+func firstPass(v *Video, segment *Segment, target int, maxBitrate int, count int, stats string, settings string) error {
+    if PathExists(stats + ".done") {
+        if done := Read(stats + ".done"); len(done) > 0 && done[0] == settings {
+            return nil
+        }
     }
-    err = Move(v.Path() + ".tmp.pt" + strconv.Itoa(count), v.Path() + ".pt" + strconv.Itoa(count))
+    ChunkStatus(count, "analyzing")
+    params := []string{}
+    params = append(params, "-i", segment.Path)
+    params = append(params, twoPassParams(v, target, maxBitrate, 1, stats)...)
+    params = append(params, "-an")
+    params = append(params, "-f", "null")
+    params = append(params, "-")
+    err := RunFfmpeg(params, segment.Seconds(), count)
     if err != nil {
-        fmt.Println(err)
+        return fmt.Errorf("failed the first pass: %v", err)
+    }
+    ChunkStatus(count, "running")
+    // The statistics are only reused for the same settings.
+    if !Write(stats + ".done", settings) {
+        return fmt.Errorf("failed to record the first pass")
     }
+    return nil
+}
+
+// Returns the video encoding parameters used for a scene. The quality is mapped onto the
+// encoder's quality knob, such as the CRF value of the x264 and x265 codecs.
+func videoParams(v *Video, maxBitrate int, quality int) []string {
+    encoder := GetParameters().Encoder()
+    rate := []string{}
+    rate = append(rate, encoder.QualityArgs(quality)...)
+    rate = append(rate, encoder.RateControlArgs(maxBitrate)...)
+    return encodeParams(v, rate)
+}
+
+// Returns the video encoding parameters used for a pass of a two-pass encode of a scene that
+// aims for the target bitrate. The statistics of the first pass are written to files starting
+// with stats.
+func twoPassParams(v *Video, target int, maxBitrate int, pass int, stats string) []string {
+    rate := []string{}
+    rate = append(rate, "-b:v", strconv.Itoa(target))
+    rate = append(rate, GetParameters().Encoder().PassArgs(pass, stats)...)
+    rate = append(rate, cappedRate(maxBitrate)...)
+    return encodeParams(v, rate)
+}
+
+func encodeParams(v *Video, rate []string) []string {
+    encoder := GetParameters().Encoder()
+    params := []string{}
+    params = append(params, "-map", "0:v:0")
+    // This needs to be set before the video filter because the video filter does scaling 
+    // and we want to denoise the video before scaling the video.
+    params = append(params, encoder.GrainArgs(v.Tune())...)
+    // Add video filters such as scaling, denoising, and deinterlacing.
+    params = append(params, "-vf", v.Filter(true))
+    // Setting vsync to 1 forces a constant frame rate.
+    params = append(params, "-vsync", "1")
+    // Limit threads here; we are using parallel processing of multiple scenes instead of 
+    // ffmpeg's native multithreading. This is needed because a lot of the video filters 
+    // are slow because they are not multi-threaded.
+    params = append(params, "-threads", "1")
+    params = append(params, "-vcodec", encoder.Codec())
+    params = append(params, "-r", v.Fps())
+    params = append(params, encoder.SpeedArgs()...)
+    params = append(params, encoder.TuneArgs(v.Tune())...)
+    params = append(params, encoder.ProfileArgs()...)
+    params = append(params, encoder.GopArgs(GetParameters().GOP())...)
+    params = append(params, rate...)
+    return mergeCodecParams(params)
 }
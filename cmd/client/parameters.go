@@ -1,73 +1,196 @@
 package main
 
 import (
-    "fmt"
     "os"
-    "os/exec"
     "strings"
-    "io/ioutil"
     "path/filepath"
     "flag"
+    "regexp"
     "strconv"
     "runtime"
+    "math"
+    "time"
 )
 
 var VideoExtensions []string = []string{"mp4", "mkv", "webm"}
 var PresetValues string = " ultrafast superfast veryfast faster fast medium slow slower veryslow placebo "
+var ModeValues string = " optimize ingest watch daemon jobs cancel retry move trash purge restore "
 var params *Parameters
 
 type Parameters struct {
+    mode        string
     path        string
     filter      string
     bitrate     int
+    peak        int
     cores       int
     gop         int
     force8Bit   bool
     forceAvc    bool
     forceAv1    bool
+    codec       string
+    tune        string
     dryRun      bool
     skipCleanup bool
     skipCrop    bool
     skipDecomb  bool
     skipDenoise bool
     skipNnedi   bool
+    skipReview  bool
+    budget      bool
+    twoPass     bool
+    frameTol    int
+    retries     int
+    durationTol float64
+    threshold   float64
+    minChunk    int
+    maxChunk    int
+    maxChunks   int
+    stall       int
+    timeout     int
+    keepLogs    int
+    trash       string
+    trashDays   int
+    trashSize   int
+    all         bool
+    owner       string
+    fileMode    string
+    keepMtime   bool
+    uid         int
+    gid         int
+    owned       bool
+    perm        os.FileMode
+    logLevel    string
+    logFormat   string
+    level       LogLevel
+    poll        bool
+    interval    int
+    settle      int
+    api         string
+    job         int
+    position    int
+    targetSsim  float64
+    targetPsnr  float64
+    searchSecs  int
+    minSsim     float64
+    minPsnr     float64
     help        bool
     preset      string
     acodec      string
 }
 
 func ParseFlags() *Parameters {
-    pathPtr := flag.String("path", "unknown", "The path to the directory to scan.")
+    modePtr := flag.String("mode", "optimize", "The mode to run in. Use ingest to move loose video files into Title/Title.ext folders. Use daemon to run the job queue, or watch to also queue titles as they are added or changed. Use jobs, cancel, retry and move to manage the daemon's queue. Use trash to list the originals in the trash and purge to empty it. Use restore to put the titles matching -filter back the way they were before they were optimized. Valid mode values are:" + ModeValues)
+    pathPtr := flag.String("path", "unknown", "The path to the directory to scan. Multiple library roots can be supplied by separating them with a \"" + string(os.PathListSeparator) + "\".")
     filterPtr := flag.String("filter", ".*", "A regex value. Only scan movies whose title matches this value.")
     bitrarePtr := flag.Int("bitrate", 2000000, "Maximum bitrate of the resulting video.")
+    tunePtr := flag.String("tune", "none", "The tuning profile that adjusts the denoiser and encoder to the content of the video. Use none to keep the default settings, or auto to pick the profile by sampling frames of the video. Valid tune values are:" + TuneValues)
+    peakPtr := flag.Int("peakBitrate", 0, "Maximum bitrate of any scene of the resulting video when the bitrate is shared out with -budget, such as the peak that Plex direct play allows. It may not be below the bitrate. Defaults to one and a half times the bitrate.")
     coresPtr := flag.Int("cores", 0, "Number of CPU cores to use to encode the video. Defaults to one less than the total number of CPU cores.")
     gopPtr := flag.Int("gop", 250, "Maximum number of frames before forcing a keyframe. Larger values increase visual quality.")
     force8BitPtr := flag.Bool("force8Bit", false, "Supply this flag when the resulting video's color depth should be 8-bit instead of 10-bit.")
-    forceAvcPtr := flag.Bool("forceAvc", false, "Supply this flag when the resulting video's codec should be AVC instead of HEVC.")
-    forceAv1Ptr := flag.Bool("forceAv1", false, "Supply this flag when the resulting video's codec should be AV1 instead of HEVC.")
+    forceAvcPtr := flag.Bool("forceAvc", false, "Supply this flag when the resulting video's codec should be AVC instead of HEVC. Same as -codec avc.")
+    forceAv1Ptr := flag.Bool("forceAv1", false, "Supply this flag when the resulting video's codec should be AV1 instead of HEVC. Same as -codec av1.")
+    codecPtr := flag.String("codec", "hevc", "The codec of the resulting video. Use av1 for the libaom encoder or svtav1 for the faster SVT-AV1 encoder. Valid codec values are:" + CodecValues)
     dryRunPtr := flag.Bool("dryRun", false, "Supply this flag when the video encoding step should be skipped.")
     skipCleanupPtr := flag.Bool("skipCleanup", false, "Supply this flag when the original videos should not be discarded.")
     skipCropPtr := flag.Bool("skipCrop", false, "Supply this flag when letter-box bars in the source video should not be removed.")
     skipDecombPtr := flag.Bool("skipDecomb", false, "Supply this flag when interlaced video should not be converted to progressive video.")
     skipDenoisePtr := flag.Bool("skipDenoise", false, "Supply this flag when the denoiser should not be used before scaling the video.")
     skipNnediPtr := flag.Bool("skipNnedi", false, "Supply this flag when the nnedi upscaler not be used to scale the video.")
+    twoPassPtr := flag.Bool("twoPass", false, "Supply this flag when each scene should be encoded in two passes at a target bitrate instead of at a constant quality. This guarantees the bitrate is not exceeded.")
+    retriesPtr := flag.Int("retries", 2, "Number of times a scene that failed to encode is retried before the title fails.")
+    frameTolPtr := flag.Int("frameTolerance", 0, "Number of frames an optimized scene may differ from the original by before the original video is kept.")
+    durationTolPtr := flag.Float64("durationTolerance", 1, "Number of seconds an optimized scene or video may differ from the original by before the original video is kept.")
+    thresholdPtr := flag.Float64("sceneThreshold", 0.5, "How much a frame must differ from the frame before it, from 0 to 1, to be detected as a scene change. Lower values detect more scene changes.")
+    minChunkPtr := flag.Int("minChunk", 0, "Minimum number of seconds in a chunk of the video that is encoded on its own. Scene changes closer together are ignored. Defaults to 300 seconds, or less when needed to keep every core busy.")
+    maxChunkPtr := flag.Int("maxChunk", 0, "Maximum number of seconds in a chunk of the video that is encoded on its own. Longer chunks are split evenly. Defaults to no maximum.")
+    maxChunksPtr := flag.Int("maxChunks", 0, "Maximum number of chunks the video is split into. The shortest chunks are merged until there are no more. Defaults to no maximum.")
+    stallPtr := flag.Int("stallTimeout", 600, "Number of seconds ffmpeg may go without making progress before it is killed, such as when a network mount stops responding. Use 0 to wait forever.")
+    timeoutPtr := flag.Int("commandTimeout", 7200, "Number of seconds an ffprobe or analysis command may run for before it is killed. Use 0 to wait forever.")
+    trashPtr := flag.String("trash", ".trash", "The folder that replaced originals are moved to, keeping their paths relative to the library root. A relative folder is kept inside each library root. Use an empty value to delete the originals instead.")
+    trashDaysPtr := flag.Int("trashDays", 30, "Number of days originals are kept in the trash before they are purged. Use 0 to keep them until the trash is purged.")
+    allPtr := flag.Bool("all", false, "Supply this flag with the purge mode to purge every original in the trash instead of only those older than -trashDays.")
+    trashSizePtr := flag.Int("trashSize", 0, "Number of gigabytes the trash of each library root may hold. The oldest originals are purged until it fits. Defaults to no cap.")
+    ownerPtr := flag.String("owner", "", "The user and group, as user:group by name or id, of the files written to the library that have no original to take theirs from. Without a group, the user's primary group is used, or the group of the file is kept when the user is not known. Used as well when the original's owner cannot be read.")
+    fileModePtr := flag.String("fileMode", "", "The octal mode, ie: 0644, of the files written to the library that have no original to take theirs from. Used as well when the original's mode cannot be read.")
+    keepMtimePtr := flag.Bool("keepMtime", false, "Supply this flag when an optimized video should keep the modification time of the original video it replaces.")
+    keepLogsPtr := flag.Int("keepLogs", 10, "Number of runs of each title whose ffmpeg and ffprobe logs are kept. Use 0 to keep no logs.")
+    logLevelPtr := flag.String("logLevel", "info", "The lowest level of the lines that are logged. Valid log level values are:" + LogLevelValues)
+    logFormatPtr := flag.String("logFormat", "text", "The format of the lines that are logged. Use json to ship the logs elsewhere. Valid log format values are:" + LogFormatValues)
+    budgetPtr := flag.Bool("budget", false, "Supply this flag to share the bitrate out across the scenes by how complex they are instead of capping every scene at the same bitrate. Adds a fast analysis encode of every scene.")
+    skipReviewPtr := flag.Bool("skipReview", false, "Supply this flag when the optimized video should not be compared to the original video after encoding.")
+    pollPtr := flag.Bool("poll", false, "Supply this flag when watch mode should poll for changes instead of using inotify. Needed for network mounts.")
+    intervalPtr := flag.Int("interval", 300, "Number of seconds between scans of the library roots in watch mode.")
+    settlePtr := flag.Int("settle", 120, "Number of seconds a title's files must stay unchanged before watch mode picks it up.")
+    apiPtr := flag.String("api", "127.0.0.1:7878", "The address of the daemon's HTTP API. When a daemon is listening the optimize mode adds titles to its queue instead of optimizing them itself.")
+    jobPtr := flag.Int("job", 0, "The id of the job to cancel, retry or move.")
+    positionPtr := flag.Int("position", 0, "The position among the pending jobs to move the job to. A position of 0 runs the job next.")
+    targetSsimPtr := flag.Float64("targetSsim", 0, "When set, each scene is trial encoded at several quality values and the cheapest one whose SSIM meets this target is used, ie: 0.98.")
+    targetPsnrPtr := flag.Float64("targetPsnr", 0, "When set, each scene is trial encoded at several quality values and the cheapest one whose PSNR meets this target is used, ie: 42.")
+    searchSecsPtr := flag.Int("searchSeconds", 20, "Number of seconds taken from the middle of each scene for the trial encodes of the quality search.")
+    minSsimPtr := flag.Float64("minSsim", 0.95, "Scenes of the optimized video whose lowest SSIM compared to the original video falls below this value are flagged and the title's original videos are kept for review.")
+    minPsnrPtr := flag.Float64("minPsnr", 0, "Scenes of the optimized video whose lowest PSNR compared to the original video falls below this value are flagged and the title's original videos are kept for review, ie: 30.")
     presetPtr := flag.String("preset", "slow", "The preset to use. Slower preset values will produce better video quality. Valid preset values are:" + PresetValues)
     flag.Parse()
     params = &Parameters{}
+    params.mode = *modePtr
     params.path = *pathPtr
     params.filter = *filterPtr
     params.bitrate = *bitrarePtr
+    params.peak = *peakPtr
     params.gop = *gopPtr
     params.cores = *coresPtr
     params.force8Bit = *force8BitPtr
     params.forceAvc = *forceAvcPtr
     params.forceAv1 = *forceAv1Ptr
+    params.codec = *codecPtr
+    params.tune = *tunePtr
+    if params.forceAvc {
+        params.codec = "avc"
+    } else if params.forceAv1 {
+        params.codec = "av1"
+    }
     params.dryRun = *dryRunPtr
     params.skipCleanup = *skipCleanupPtr
     params.skipCrop = *skipCropPtr
     params.skipDecomb = *skipDecombPtr
     params.skipDenoise = *skipDenoisePtr
     params.skipNnedi = *skipNnediPtr
+    params.skipReview = *skipReviewPtr
+    params.budget = *budgetPtr
+    params.twoPass = *twoPassPtr
+    params.frameTol = *frameTolPtr
+    params.retries = *retriesPtr
+    params.durationTol = *durationTolPtr
+    params.threshold = *thresholdPtr
+    params.minChunk = *minChunkPtr
+    params.maxChunk = *maxChunkPtr
+    params.maxChunks = *maxChunksPtr
+    params.stall = *stallPtr
+    params.timeout = *timeoutPtr
+    params.keepLogs = *keepLogsPtr
+    params.trash = *trashPtr
+    params.trashDays = *trashDaysPtr
+    params.trashSize = *trashSizePtr
+    params.all = *allPtr
+    params.owner = *ownerPtr
+    params.fileMode = *fileModePtr
+    params.keepMtime = *keepMtimePtr
+    params.logLevel = *logLevelPtr
+    params.logFormat = *logFormatPtr
+    params.level = parseLogLevel(params.logLevel)
+    params.poll = *pollPtr
+    params.interval = *intervalPtr
+    params.settle = *settlePtr
+    params.api = *apiPtr
+    params.job = *jobPtr
+    params.position = *positionPtr
+    params.targetSsim = *targetSsimPtr
+    params.targetPsnr = *targetPsnrPtr
+    params.searchSecs = *searchSecsPtr
+    params.minSsim = *minSsimPtr
+    params.minPsnr = *minPsnrPtr
     params.preset = *presetPtr
     return params
 }
@@ -77,34 +200,200 @@ func GetParameters() *Parameters {
 }
 
 func (p *Parameters) Println() {
-    fmt.Println("path:", p.path)
-    fmt.Println("filter:", p.filter)
-    fmt.Println("bitrate:", p.bitrate)
-    fmt.Println("cores:", p.Cores())
-    fmt.Println("gop:", p.gop)
-    fmt.Println("force8Bit:", p.force8Bit)
-    fmt.Println("forceAvc:", p.forceAvc)
-    fmt.Println("forceAv1:", p.forceAv1)
-    fmt.Println("dryRun:", p.dryRun)
-    fmt.Println("skipCleanup:", p.skipCleanup)
-    fmt.Println("skipDenoise:", p.skipDenoise)
-    fmt.Println("skipDecomb:", p.skipDecomb)
-    fmt.Println("skipCrop:", p.skipCrop)
-    fmt.Println("preset:", p.preset)
+    Infof("mode: %v", p.mode)
+    Infof("path: %v", p.path)
+    Infof("filter: %v", p.filter)
+    Infof("bitrate: %v", p.bitrate)
+    Infof("peakBitrate: %v", p.PeakBitrate())
+    Infof("cores: %v", p.Cores())
+    Infof("gop: %v", p.gop)
+    Infof("force8Bit: %v", p.force8Bit)
+    Infof("codec: %v", p.codec)
+    Infof("tune: %v", p.tune)
+    Infof("dryRun: %v", p.dryRun)
+    Infof("skipCleanup: %v", p.skipCleanup)
+    Infof("skipDenoise: %v", p.skipDenoise)
+    Infof("skipDecomb: %v", p.skipDecomb)
+    Infof("skipCrop: %v", p.skipCrop)
+    Infof("skipReview: %v", p.skipReview)
+    Infof("budget: %v", p.budget)
+    Infof("twoPass: %v", p.twoPass)
+    Infof("frameTolerance: %v", p.frameTol)
+    Infof("retries: %v", p.retries)
+    Infof("durationTolerance: %v", p.durationTol)
+    Infof("sceneThreshold: %v", p.threshold)
+    Infof("minChunk: %v", p.minChunk)
+    Infof("maxChunk: %v", p.maxChunk)
+    Infof("maxChunks: %v", p.maxChunks)
+    Infof("stallTimeout: %v", p.stall)
+    Infof("commandTimeout: %v", p.timeout)
+    Infof("keepLogs: %v", p.keepLogs)
+    Infof("trash: %v", p.trash)
+    Infof("trashDays: %v", p.trashDays)
+    Infof("trashSize: %v", p.trashSize)
+    Infof("all: %v", p.all)
+    Infof("owner: %v", p.owner)
+    Infof("fileMode: %v", p.fileMode)
+    Infof("keepMtime: %v", p.keepMtime)
+    Infof("logLevel: %v", p.logLevel)
+    Infof("logFormat: %v", p.logFormat)
+    Infof("poll: %v", p.poll)
+    Infof("interval: %v", p.interval)
+    Infof("settle: %v", p.settle)
+    Infof("api: %v", p.api)
+    Infof("targetSsim: %v", p.targetSsim)
+    Infof("targetPsnr: %v", p.targetPsnr)
+    Infof("minSsim: %v", p.minSsim)
+    Infof("minPsnr: %v", p.minPsnr)
+    Infof("preset: %v", p.preset)
+}
+
+func (p *Parameters) Mode() string {
+    return p.mode
 }
 
 func (p *Parameters) InputDir() string {
     return p.path
 }
 
+// Roots returns each library root supplied by the path flag. Roots always end with a slash.
+func (p *Parameters) Roots() []string {
+    roots := make([]string, 0)
+    for _, root := range filepath.SplitList(p.path) {
+        if root == "" {
+            continue
+        }
+        if !strings.HasSuffix(root, "/") {
+            root = root + "/"
+        }
+        roots = append(roots, root)
+    }
+    return roots
+}
+
 func (p *Parameters) Filter() string {
     return p.filter
 }
 
+// Matches returns true when the title matches the filter regex.
+func (p *Parameters) Matches(title string) bool {
+    matched, err := regexp.MatchString(p.filter, title)
+    return err == nil && matched
+}
+
 func (p *Parameters) Bitrate() int {
     return p.bitrate
 }
 
+func (p *Parameters) PeakBitrate() int {
+    if p.peak <= 0 {
+        return p.bitrate + p.bitrate / 2
+    }
+    return p.peak
+}
+
+func (p *Parameters) TwoPass() bool {
+    return p.twoPass
+}
+
+func (p *Parameters) Retries() int {
+    return p.retries
+}
+
+func (p *Parameters) FrameTolerance() int {
+    return p.frameTol
+}
+
+func (p *Parameters) DurationTolerance() float64 {
+    return p.durationTol
+}
+
+func (p *Parameters) SceneThreshold() float64 {
+    return p.threshold
+}
+
+// MinChunk returns the minimum number of seconds in a chunk of a video of the duration. Unless
+// set by the minChunk flag, chunks are short enough that there is a chunk for every core.
+func (p *Parameters) MinChunk(duration float64) float64 {
+    if p.minChunk > 0 {
+        return float64(p.minChunk)
+    }
+    return math.Min(defaultMinChunk, duration / float64(p.Cores()))
+}
+
+// MaxChunk returns the maximum number of seconds in a chunk, or 0 when there is no maximum.
+func (p *Parameters) MaxChunk() float64 {
+    return float64(p.maxChunk)
+}
+
+// MaxChunks returns the maximum number of chunks, or 0 when there is no maximum.
+func (p *Parameters) MaxChunks() int {
+    return p.maxChunks
+}
+
+// StallTimeout returns how long ffmpeg may go without making progress, or 0 to wait forever.
+func (p *Parameters) StallTimeout() time.Duration {
+    return time.Duration(p.stall) * time.Second
+}
+
+// CommandTimeout returns how long a command without progress reporting may run, or 0 to wait
+// forever.
+func (p *Parameters) CommandTimeout() time.Duration {
+    return time.Duration(p.timeout) * time.Second
+}
+
+func (p *Parameters) LogLevel() LogLevel {
+    return p.level
+}
+
+func (p *Parameters) LogFormat() string {
+    return p.logFormat
+}
+
+func (p *Parameters) KeepLogs() int {
+    return p.keepLogs
+}
+
+func (p *Parameters) Trash() string {
+    return p.trash
+}
+
+// TrashRetention returns how long originals are kept in the trash, or 0 to keep them until the
+// trash is purged.
+func (p *Parameters) TrashRetention() time.Duration {
+    return time.Duration(p.trashDays) * 24 * time.Hour
+}
+
+// All returns true when the purge mode should purge every original in the trash.
+func (p *Parameters) All() bool {
+    return p.all
+}
+
+// TrashSize returns the number of bytes the trash of each library root may hold, or 0 for no cap.
+func (p *Parameters) TrashSize() int64 {
+    return int64(p.trashSize) << 30
+}
+
+// Owner returns the user and group of -owner, or the group -1 when the group a file already has
+// is kept. Returns false when it was not supplied.
+func (p *Parameters) Owner() (int, int, bool) {
+    return p.uid, p.gid, p.owned
+}
+
+// FileMode returns the mode of -fileMode, or 0 when it was not supplied.
+func (p *Parameters) FileMode() os.FileMode {
+    return p.perm
+}
+
+func (p *Parameters) KeepMtime() bool {
+    return p.keepMtime
+}
+
+// Budget returns true when the bitrate should be shared out across scenes by complexity.
+func (p *Parameters) Budget() bool {
+    return p.budget
+}
+
 func (p *Parameters) Cores() int {
     if p.cores < 1 {
         p.cores = runtime.NumCPU() - 1
@@ -124,16 +413,13 @@ func (p *Parameters) Force8Bit() bool {
     return p.force8Bit
 }
 
-func (p *Parameters) ForceAvc() bool {
-    return p.forceAvc
+func (p *Parameters) Tune() string {
+    return p.tune
 }
 
-func (p *Parameters) ForceHevc() bool {
-    return !p.forceAvc && !p.forceAv1
-}
-
-func (p *Parameters) ForceAv1() bool {
-    return p.forceAv1
+// Encoder returns the encoder of the selected codec.
+func (p *Parameters) Encoder() Encoder {
+    return GetEncoder(p.codec)
 }
 
 func (p *Parameters) DryRun() bool {
@@ -156,6 +442,63 @@ func (p *Parameters) Nnedi() bool {
     return !p.skipNnedi
 }
 
+func (p *Parameters) Poll() bool {
+    return p.poll
+}
+
+func (p *Parameters) Interval() time.Duration {
+    return time.Duration(p.interval) * time.Second
+}
+
+func (p *Parameters) Settle() time.Duration {
+    return time.Duration(p.settle) * time.Second
+}
+
+func (p *Parameters) Api() string {
+    return p.api
+}
+
+func (p *Parameters) Job() int {
+    return p.job
+}
+
+func (p *Parameters) Position() int {
+    return p.position
+}
+
+// QualitySearch returns true when a quality target is set.
+func (p *Parameters) QualitySearch() bool {
+    return p.targetSsim > 0 || p.targetPsnr > 0
+}
+
+func (p *Parameters) TargetSsim() float64 {
+    return p.targetSsim
+}
+
+func (p *Parameters) TargetPsnr() float64 {
+    return p.targetPsnr
+}
+
+func (p *Parameters) SearchSeconds() int {
+    if p.searchSecs < 1 {
+        return 1
+    }
+    return p.searchSecs
+}
+
+// Review returns true when the optimized video should be compared to the original video.
+func (p *Parameters) Review() bool {
+    return !p.skipReview
+}
+
+func (p *Parameters) MinSsim() float64 {
+    return p.minSsim
+}
+
+func (p *Parameters) MinPsnr() float64 {
+    return p.minPsnr
+}
+
 func (p *Parameters) Help() bool {
     return p.help
 }
@@ -170,10 +513,75 @@ func (p *Parameters) Preset() string {
 }
 
 func (p *Parameters) Valid() bool {
+    if !strings.Contains(ModeValues, " " + p.mode + " ") {
+        Errorf("ILLEGAL MODE: %v", p.mode)
+        return false
+    }
+    if _, err := regexp.Compile(p.filter); err != nil {
+        Errorf("ILLEGAL FILTER: %v", p.filter)
+        return false
+    }
+    // Restoring the whole library by accident would undo every optimization.
+    if p.mode == "restore" && p.filter == ".*" {
+        Errorf("ILLEGAL FILTER: %v (supply -filter with the titles to restore)", p.filter)
+        return false
+    }
     if !strings.Contains(PresetValues, " " + p.preset + " ") {
-        fmt.Println("ILLEGAL PRESET:", p.preset)
+        Errorf("ILLEGAL PRESET: %v", p.preset)
+        return false
+    }
+    if !strings.Contains(CodecValues, " " + p.codec + " ") {
+        Errorf("ILLEGAL CODEC: %v", p.codec)
+        return false
+    }
+    if !strings.Contains(TuneValues, " " + p.tune + " ") {
+        Errorf("ILLEGAL TUNE: %v", p.tune)
         return false
     }
+    if p.peak > 0 && p.peak < p.bitrate {
+        Errorf("ILLEGAL PEAK BITRATE: %v (it may not be below the bitrate of %v)", p.peak, p.bitrate)
+        return false
+    }
+    if !strings.Contains(LogLevelValues, " " + p.logLevel + " ") {
+        Errorf("ILLEGAL LOG LEVEL: %v", p.logLevel)
+        return false
+    }
+    if !strings.Contains(LogFormatValues, " " + p.logFormat + " ") {
+        Errorf("ILLEGAL LOG FORMAT: %v", p.logFormat)
+        return false
+    }
+    if p.threshold <= 0 || p.threshold > 1 {
+        Errorf("ILLEGAL SCENE THRESHOLD: %v", p.threshold)
+        return false
+    }
+    if p.maxChunk > 0 && p.maxChunk < p.minChunk {
+        Errorf("ILLEGAL MAX CHUNK: %v", p.maxChunk)
+        return false
+    }
+    if p.trashDays < 0 {
+        Errorf("ILLEGAL TRASH DAYS: %v", p.trashDays)
+        return false
+    }
+    if p.trashSize < 0 {
+        Errorf("ILLEGAL TRASH SIZE: %v", p.trashSize)
+        return false
+    }
+    if p.owner != "" {
+        uid, gid, err := parseOwner(p.owner)
+        if err != nil {
+            Errorf("ILLEGAL OWNER: %v (%v)", p.owner, err)
+            return false
+        }
+        p.uid, p.gid, p.owned = uid, gid, true
+    }
+    if p.fileMode != "" {
+        mode, err := strconv.ParseUint(p.fileMode, 8, 32)
+        if err != nil || mode == 0 || mode > 0777 {
+            Errorf("ILLEGAL FILE MODE: %v", p.fileMode)
+            return false
+        }
+        p.perm = os.FileMode(mode)
+    }
     return true
 }
 
@@ -197,32 +605,11 @@ func (p *Parameters) ScalingAlgo() string {
     return "spline"
 }
 
-func (p *Parameters) VideoProfile() string {
-    if p.force8Bit {
-        return "main"
-    } else if p.forceAvc {
-        return "high10"
-    } else {
-        return "main10"
-    }
-}
-
-func (p *Parameters) VideoCodec() string {
-    if p.forceAvc == true {
-        return "libx264"
-    } else if p.forceAv1 == true {
-        return "libaom-av1"
-    } else {
-        return "libx265"
-    }
-}
-
 func (p *Parameters) AudioCodec() string {
     if p.acodec != "" {
         return p.acodec
     }
-    cmd := exec.Command("ffmpeg", "-version")
-    stdout, _ := cmd.Output()
+    stdout, _, _ := Ffmpeg("-version")
     if strings.Contains(string(stdout), "--enable-libopus") {
         p.acodec = "libopus"
     } else {
@@ -231,22 +618,32 @@ func (p *Parameters) AudioCodec() string {
     return p.acodec
 }
 
-func (p *Parameters) Cleanup(path string) {
+// Cleanup moves the originals kept in the title's folder to the trash, or deletes them when there
+// is no trash, once the replacement that took their place passes verification. The originals are
+// kept while the title is waiting for review.
+func (p *Parameters) Cleanup(path string, replacement string) {
     if p.skipCleanup {
         return
     }
-    files, err := ioutil.ReadDir(path)
-    if err != nil {
-        fmt.Println(err)
+    if PathExists(filepath.Join(path, ReviewFile)) {
+        Infof("Keeping original files for review. Delete this file once reviewed: %s", filepath.Join(path, ReviewFile))
         return
     }
-    for _, file := range files {
-        if file.Name() == "orig" {
-            fmt.Println("Discarding original files stored in:", filepath.Join(path, file.Name()))
-            os.RemoveAll(filepath.Join(path, file.Name()))
-        } else if strings.HasSuffix(file.Name(), ".orig") {
-            fmt.Println("Discarding original file:", filepath.Join(path, file.Name()))
-            os.Remove(filepath.Join(path, file.Name()))
-        }
+    originals := findOriginals(path)
+    if len(originals) == 0 {
+        return
+    }
+    ProgressStep("Verifying the replacement")
+    if err := verifyReplacement(replacement, originals); err != nil {
+        Warnf("Keeping original files because %s failed verification: %v", replacement, err)
+        return
+    }
+    root := filepath.Dir(filepath.Clean(path))
+    batch := trashBatch(root)
+    for _, original := range originals {
+        discard(root, batch, original, replacement)
+    }
+    if batch != "" {
+        pruneTrash(trashDir(root))
     }
 }
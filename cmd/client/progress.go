@@ -0,0 +1,233 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// The number of log lines kept for the title being processed.
+const progressLogLines = 200
+// How often the progress is printed to the console.
+const progressPrintInterval = 30 * time.Second
+
+type ChunkProgress struct {
+    Index   int     `json:"index"`
+    Start   float64 `json:"start"`
+    End     float64 `json:"end"`
+    Status  string  `json:"status"`
+    Percent float64 `json:"percent"`
+    Fps     float64 `json:"fps"`
+    Speed   float64 `json:"speed"`
+    Quality int     `json:"quality"`
+}
+
+// Progress describes the title that is currently being processed. While chunks are being
+// encoded the percent, fps, speed and ETA cover all of the chunks; otherwise they cover the
+// current step.
+type Progress struct {
+    Title   string           `json:"title"`
+    Step    string           `json:"step"`
+    Started time.Time        `json:"started"`
+    Percent float64          `json:"percent"`
+    Fps     float64          `json:"fps"`
+    Speed   float64          `json:"speed"`
+    Eta     float64          `json:"eta"`
+    Chunks  []*ChunkProgress `json:"chunks"`
+    Log     []string         `json:"log"`
+    printed time.Time
+    runLogs       string
+    runLogsFailed bool
+    logCount      int
+    logPath       string
+    logFile       *os.File
+    logFailed     bool
+    heldLines     []string
+}
+
+var progress *Progress
+var progressLock sync.Mutex
+
+// StartProgress starts tracking a new title.
+func StartProgress(title string) {
+    // The metadata directory is made before the lock is taken, since failing to make it is logged.
+    started := time.Now()
+    logPath := titleLogPath(title, started)
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    progress = &Progress{}
+    progress.Title = title
+    progress.Started = started
+    progress.logPath = logPath
+    progress.printed = time.Now()
+    progress.Chunks = make([]*ChunkProgress, 0)
+    progress.Log = make([]string, 0)
+}
+
+// StopProgress stops tracking the current title and returns its log.
+func StopProgress() []string {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return nil
+    }
+    log := progress.Log
+    if progress.logFile != nil {
+        progress.logFile.Close()
+    }
+    progress = nil
+    return log
+}
+
+// CurrentProgress returns a copy of the current title's progress, or nil when idle.
+func CurrentProgress() *Progress {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return nil
+    }
+    current := *progress
+    current.Chunks = make([]*ChunkProgress, len(progress.Chunks))
+    for i, chunk := range progress.Chunks {
+        copied := *chunk
+        current.Chunks[i] = &copied
+    }
+    current.Log = append([]string{}, progress.Log...)
+    return &current
+}
+
+// ProgressStep names the step the current title is on, ie: "Optimizing video".
+func ProgressStep(step string) {
+    Progressf("%s.", step)
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress != nil {
+        progress.Step = step
+        progress.Percent = 0
+        progress.Fps = 0
+        progress.Speed = 0
+        progress.Eta = 0
+    }
+}
+
+// StepProgress updates the progress of the current step. The ETA is in seconds.
+func StepProgress(percent float64, fps float64, speed float64, eta float64) {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return
+    }
+    progress.Percent = percent
+    progress.Fps = fps
+    progress.Speed = speed
+    progress.Eta = eta
+    progress.print()
+}
+
+// Progressf logs the message at the info level and adds it to the current title's log.
+func Progressf(format string, a ...interface{}) {
+    Logf(LevelInfo, noChunk, format, a...)
+}
+
+// AddChunk registers a chunk of the current title that spans from start to end seconds.
+func AddChunk(index int, start float64, end float64) {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return
+    }
+    chunk := &ChunkProgress{}
+    chunk.Index = index
+    chunk.Start = start
+    chunk.End = end
+    chunk.Status = "pending"
+    progress.Chunks = append(progress.Chunks, chunk)
+}
+
+// ChunkStatus updates the status of a chunk; a status of done or failed completes the chunk.
+func ChunkStatus(index int, status string) {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return
+    }
+    for _, chunk := range progress.Chunks {
+        if chunk.Index == index {
+            chunk.Status = status
+            if status == "done" || status == "failed" {
+                chunk.Percent = 100
+                chunk.Fps = 0
+                chunk.Speed = 0
+            }
+        }
+    }
+    progress.update()
+}
+
+// ChunkQuality records the quality value a chunk is encoded at.
+func ChunkQuality(index int, quality int) {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return
+    }
+    for _, chunk := range progress.Chunks {
+        if chunk.Index == index {
+            chunk.Quality = quality
+        }
+    }
+}
+
+// ChunkProgressUpdate updates the progress of a chunk that is being encoded.
+func ChunkProgressUpdate(index int, percent float64, fps float64, speed float64) {
+    progressLock.Lock()
+    defer progressLock.Unlock()
+    if progress == nil {
+        return
+    }
+    for _, chunk := range progress.Chunks {
+        if chunk.Index == index {
+            chunk.Percent = percent
+            chunk.Fps = fps
+            chunk.Speed = speed
+        }
+    }
+    progress.update()
+    progress.print()
+}
+
+// Recalculates the title's percent, fps, speed and ETA from its chunks. Chunks are encoded in
+// parallel so the fps and speed of the running chunks add up. Callers must hold the lock.
+func (p *Progress) update() {
+    total := float64(0)
+    encoded := float64(0)
+    p.Fps = 0
+    p.Speed = 0
+    for _, chunk := range p.Chunks {
+        total += chunk.End - chunk.Start
+        encoded += (chunk.End - chunk.Start) * chunk.Percent / 100
+        p.Fps += chunk.Fps
+        p.Speed += chunk.Speed
+    }
+    if total <= 0 {
+        return
+    }
+    p.Percent = 100 * encoded / total
+    p.Eta = 0
+    if p.Speed > 0 {
+        p.Eta = (total - encoded) / p.Speed
+    }
+}
+
+// Prints the progress to the console every so often. Callers must hold the lock.
+func (p *Progress) print() {
+    if time.Since(p.printed) < progressPrintInterval {
+        return
+    }
+    p.printed = time.Now()
+    eta := time.Duration(p.Eta) * time.Second
+    line := formatLine(time.Now(), LevelInfo, p.Title, noChunk, fmt.Sprintf("%s: %.1f%% at %.1f fps (%.2fx), %v remaining.", p.Step, p.Percent, p.Fps, p.Speed, eta))
+    os.Stdout.WriteString(line)
+    p.writeLog(line)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "sync"
+)
+
+// The number of quality values tried for each chunk, starting from the default quality.
+const qualityCandidates = 5
+
+var ssimPattern = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+var psnrPattern = regexp.MustCompile(`PSNR .*average:([0-9.]+|inf)`)
+var qualityLock sync.Mutex
+
+// QualityResult records the quality value chosen for a chunk and how the trial encode scored.
+type QualityResult struct {
+    Chunk   int     `json:"chunk"`
+    Quality int     `json:"quality"`
+    Ssim    float64 `json:"ssim"`
+    Psnr    float64 `json:"psnr"`
+    Bitrate int     `json:"bitrate"`
+}
+
+// Runs trial encodes of a sample taken from the middle of the chunk at several quality values
+// and returns the cheapest quality value whose SSIM and PSNR meet the quality targets. The
+// default quality is returned when none of them do. Choices are recorded in quality.json in
+// the title's work directory so that they are reused when the title is resumed.
+func searchQuality(v *Video, segment *Segment, maxBitrate int, count int) int {
+    encoder := GetParameters().Encoder()
+    record := filepath.Join(filepath.Dir(v.Path()), "quality.json")
+    if result, ok := readQuality(record)[count]; ok {
+        return result.Quality
+    }
+    Logf(LevelInfo, count, "Searching for the quality of the scene.")
+    length := float64(GetParameters().SearchSeconds())
+    if length > segment.Seconds() {
+        length = segment.Seconds()
+    }
+    sampleStart := strconv.FormatFloat((segment.Seconds() - length) / 2, 'f', 3, 64)
+    sampleLength := strconv.FormatFloat(length, 'f', 3, 64)
+    reference := TrackTmp(v.Path() + ".reference.pt" + strconv.Itoa(count) + ".mkv")
+    defer RemoveTmp(reference)
+    // The reference is the source run through the same filters, encoded losslessly.
+    params := []string{}
+    params = append(params, "-ss", sampleStart)
+    params = append(params, "-t", sampleLength)
+    params = append(params, "-i", segment.Path)
+    params = append(params, "-map", "0:v:0")
+    params = append(params, "-vf", v.Filter(true))
+    params = append(params, "-vsync", "1")
+    params = append(params, "-threads", "1")
+    params = append(params, "-r", v.Fps())
+    params = append(params, "-c:v", "ffv1")
+    params = append(params, "-an")
+    params = append(params, "-y")
+    params = append(params, reference)
+    if err := RunFfmpeg(params, length, untracked); err != nil {
+        Logf(LevelWarn, count, "Failed to create the reference for the scene: %v", err)
+        return encoder.DefaultQuality()
+    }
+    chosen := QualityResult{}
+    chosen.Chunk = count
+    chosen.Quality = encoder.DefaultQuality()
+    for i := 0; i < qualityCandidates; i++ {
+        quality := encoder.DefaultQuality() + i * encoder.QualityStep()
+        trial := TrackTmp(v.Path() + ".trial.pt" + strconv.Itoa(count) + ".mp4")
+        params := []string{}
+        params = append(params, "-ss", sampleStart)
+        params = append(params, "-t", sampleLength)
+        params = append(params, "-i", segment.Path)
+        params = append(params, videoParams(v, maxBitrate, quality)...)
+        params = append(params, "-an")
+        params = append(params, "-f", "mp4")
+        params = append(params, "-y")
+        params = append(params, trial)
+        err := RunFfmpeg(params, length, untracked)
+        if err != nil {
+            Logf(LevelWarn, count, "Failed the trial encode at quality %v: %v", quality, err)
+            RemoveTmp(trial)
+            break
+        }
+        ssim, psnr, err := measureQuality(trial, reference)
+        info, statErr := os.Stat(trial)
+        RemoveTmp(trial)
+        if err != nil || statErr != nil {
+            Logf(LevelWarn, count, "Failed to measure the trial encode at quality %v: %v", quality, err)
+            break
+        }
+        bitrate := int(float64(info.Size()) * 8 / length)
+        Logf(LevelInfo, count, "Quality %v: ssim %.4f, psnr %.2f, %v bps.", quality, ssim, psnr, bitrate)
+        if !meetsQualityTarget(ssim, psnr) {
+            break
+        }
+        // Keep the cheapest quality value that meets the targets.
+        if i == 0 || bitrate < chosen.Bitrate {
+            chosen.Quality = quality
+            chosen.Ssim = ssim
+            chosen.Psnr = psnr
+            chosen.Bitrate = bitrate
+        }
+    }
+    Logf(LevelInfo, count, "The scene will be encoded at quality %v.", chosen.Quality)
+    writeQuality(record, chosen)
+    return chosen.Quality
+}
+
+func meetsQualityTarget(ssim float64, psnr float64) bool {
+    if GetParameters().TargetSsim() > 0 && ssim < GetParameters().TargetSsim() {
+        return false
+    }
+    if GetParameters().TargetPsnr() > 0 && psnr < GetParameters().TargetPsnr() {
+        return false
+    }
+    return true
+}
+
+// Compares the distorted video to the reference video using ffmpeg's ssim and psnr filters.
+func measureQuality(distorted string, reference string) (float64, float64, error) {
+    params := []string{}
+    params = append(params, "-i", distorted)
+    params = append(params, "-i", reference)
+    params = append(params, "-lavfi", "[0:v]split[d1][d2];[1:v]split[r1][r2];[d1][r1]ssim;[d2][r2]psnr")
+    params = append(params, "-f", "null")
+    params = append(params, "-")
+    // The filters log their averages to stderr.
+    _, output, err := Ffmpeg(params...)
+    if err != nil {
+        return 0, 0, err
+    }
+    ssimMatch := ssimPattern.FindSubmatch(output)
+    psnrMatch := psnrPattern.FindSubmatch(output)
+    if ssimMatch == nil || psnrMatch == nil {
+        return 0, 0, fmt.Errorf("no ssim or psnr in the ffmpeg output")
+    }
+    ssim, _ := strconv.ParseFloat(string(ssimMatch[1]), 64)
+    psnr := float64(100)
+    if string(psnrMatch[1]) != "inf" {
+        psnr, _ = strconv.ParseFloat(string(psnrMatch[1]), 64)
+    }
+    return ssim, psnr, nil
+}
+
+func readQuality(record string) map[int]QualityResult {
+    qualityLock.Lock()
+    defer qualityLock.Unlock()
+    return loadQuality(record)
+}
+
+func writeQuality(record string, chosen QualityResult) {
+    qualityLock.Lock()
+    defer qualityLock.Unlock()
+    results := loadQuality(record)
+    results[chosen.Chunk] = chosen
+    data, _ := json.MarshalIndent(sortedQuality(results), "", "  ")
+    Write(record, string(data))
+}
+
+// Returns the quality results ordered by chunk.
+func sortedQuality(results map[int]QualityResult) []QualityResult {
+    list := make([]QualityResult, 0)
+    for _, result := range results {
+        list = append(list, result)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Chunk < list[j].Chunk
+    })
+    return list
+}
+
+// Callers must hold the lock.
+func loadQuality(record string) map[int]QualityResult {
+    results := make(map[int]QualityResult)
+    data, err := os.ReadFile(record)
+    if err != nil {
+        return results
+    }
+    list := make([]QualityResult, 0)
+    json.Unmarshal(data, &list)
+    for _, result := range list {
+        results[result.Chunk] = result
+    }
+    return results
+}
+
+// Prints the quality value chosen for each chunk of the title. They are kept in the quality folder
+// of the metadata directory as "Title.json", since the work directory is removed.
+func keepQuality(m *Media, path string) {
+    results := sortedQuality(readQuality(filepath.Join(path, "quality.json")))
+    for _, result := range results {
+        Progressf("Scene %v was encoded at quality %v (ssim %.4f, psnr %.2f).", result.Chunk, result.Quality, result.Ssim, result.Psnr)
+    }
+    dir := Mkdir(filepath.Join(DefaultMetadataDir(), "quality"))
+    if dir == "" {
+        return
+    }
+    data, _ := json.MarshalIndent(results, "", "  ")
+    Write(filepath.Join(dir, m.Name() + ".json"), string(data))
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+var JobKinds string = " optimize concat "
+var JobStatuses string = " pending running done failed cancelled "
+
+// The number of finished jobs to keep in the queue's history.
+const jobHistory = 500
+
+type Job struct {
+    Id       int        `json:"id"`
+    Kind     string     `json:"kind"`
+    Root     string     `json:"root"`
+    Title    string     `json:"title"`
+    Status   string     `json:"status"`
+    Error    string     `json:"error,omitempty"`
+    Attempts int        `json:"attempts"`
+    Created  time.Time  `json:"created"`
+    Started  *time.Time `json:"started,omitempty"`
+    Finished *time.Time `json:"finished,omitempty"`
+    // The size in bytes of the title's videos before and after the job ran.
+    SizeBefore int64    `json:"sizeBefore,omitempty"`
+    SizeAfter  int64    `json:"sizeAfter,omitempty"`
+    // The log of a failed job.
+    Log      []string   `json:"log,omitempty"`
+}
+
+// Queue is a persistent, ordered list of jobs. Pending jobs run in the order they appear.
+type Queue struct {
+    path   string
+    nextId int
+    jobs   []*Job
+    lock   sync.Mutex
+    wake   chan struct{}
+    // Kills the commands of the running job.
+    cancel context.CancelFunc
+}
+
+// LoadQueue reads the queue stored in the metadata directory. Jobs that were running when the
+// previous daemon stopped are put back to pending.
+func LoadQueue() (*Queue, error) {
+    q := &Queue{}
+    q.path = filepath.Join(DefaultMetadataDir(), "queue.json")
+    q.nextId = 1
+    q.jobs = make([]*Job, 0)
+    q.wake = make(chan struct{}, 1)
+    if !PathExists(q.path) {
+        return q, nil
+    }
+    data, err := os.ReadFile(q.path)
+    if err != nil {
+        return nil, err
+    }
+    if err = json.Unmarshal(data, &q.jobs); err != nil {
+        return nil, err
+    }
+    for _, job := range q.jobs {
+        if job.Status == "running" {
+            job.Status = "pending"
+            job.Started = nil
+        }
+        if job.Id >= q.nextId {
+            q.nextId = job.Id + 1
+        }
+    }
+    return q, nil
+}
+
+// Writes the queue to a temporary file first so that a crash never leaves a truncated queue.
+// Callers must hold the lock.
+func (q *Queue) save() {
+    data, err := json.MarshalIndent(q.jobs, "", "  ")
+    if err != nil {
+        Errorf("Failed to save queue: %v", err)
+        return
+    }
+    if !Write(q.path + ".tmp", string(data)) {
+        return
+    }
+    if err = os.Rename(q.path + ".tmp", q.path); err != nil {
+        Errorf("Failed to save queue: %v", err)
+    }
+}
+
+// Callers must hold the lock.
+func (q *Queue) find(id int) *Job {
+    for _, job := range q.jobs {
+        if job.Id == id {
+            return job
+        }
+    }
+    return nil
+}
+
+// Callers must hold the lock.
+func (q *Queue) active(root string, title string) *Job {
+    for _, job := range q.jobs {
+        if job.Root == root && job.Title == title && (job.Status == "pending" || job.Status == "running") {
+            return job
+        }
+    }
+    return nil
+}
+
+// Active returns true when the title has a pending or running job.
+func (q *Queue) Active(root string, title string) bool {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    return q.active(root, title) != nil
+}
+
+// Enqueue adds a job to the end of the queue. When kind is empty it is concat for titles that
+// only have " - ptN" videos and optimize otherwise. The existing job is returned when the title
+// is already pending or running. The title must be a folder of one of the library roots.
+func (q *Queue) Enqueue(kind string, root string, title string) (Job, error) {
+    if !strings.HasSuffix(root, "/") {
+        root = root + "/"
+    }
+    if !libraryRoot(root) {
+        return Job{}, fmt.Errorf("%s is not a library root", root)
+    }
+    if title == "" || title == "." || title == ".." || strings.ContainsAny(title, "/" + string(filepath.Separator)) {
+        return Job{}, fmt.Errorf("illegal title: %s", title)
+    }
+    if kind == "" {
+        kind = "optimize"
+        if GetMedia(root, title) == nil && hasParts(filepath.Join(root, title)) {
+            kind = "concat"
+        }
+    }
+    if !validValue(JobKinds, kind) {
+        return Job{}, fmt.Errorf("illegal job kind: %s", kind)
+    }
+    if GetMedia(root, title) == nil && !hasParts(filepath.Join(root, title)) {
+        return Job{}, fmt.Errorf("no movie found for %s in %s", title, root)
+    }
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    if job := q.active(root, title); job != nil {
+        return *job, nil
+    }
+    job := &Job{}
+    job.Id = q.nextId
+    job.Kind = kind
+    job.Root = root
+    job.Title = title
+    job.Status = "pending"
+    job.Created = time.Now()
+    q.nextId++
+    q.jobs = append(q.jobs, job)
+    q.save()
+    q.notify()
+    return *job, nil
+}
+
+// Returns true when the root is one of the library roots of -path.
+func libraryRoot(root string) bool {
+    for _, libraryRoot := range GetParameters().Roots() {
+        if filepath.Clean(libraryRoot) == filepath.Clean(root) {
+            return true
+        }
+    }
+    return false
+}
+
+// Jobs returns a copy of the jobs with the supplied status, or every job when status is empty.
+func (q *Queue) Jobs(status string) []Job {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    jobs := make([]Job, 0)
+    for _, job := range q.jobs {
+        if status == "" || job.Status == status {
+            jobs = append(jobs, *job)
+        }
+    }
+    return jobs
+}
+
+func (q *Queue) Job(id int) (Job, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    job := q.find(id)
+    if job == nil {
+        return Job{}, fmt.Errorf("job %v not found", id)
+    }
+    return *job, nil
+}
+
+// Cancel stops a pending job from running, or stops the running job by killing its commands.
+// The originals of a running job are kept; the job can be retried to resume it.
+func (q *Queue) Cancel(id int) (Job, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    job := q.find(id)
+    if job == nil {
+        return Job{}, fmt.Errorf("job %v not found", id)
+    }
+    if job.Status != "pending" && job.Status != "running" {
+        return *job, fmt.Errorf("job %v is %s; only pending or running jobs can be cancelled", id, job.Status)
+    }
+    if job.Status == "running" {
+        Warnf("### Cancelling job %v: %s %s.", job.Id, job.Kind, job.Title)
+        if q.cancel != nil {
+            q.cancel()
+        }
+    } else {
+        now := time.Now()
+        job.Finished = &now
+    }
+    job.Status = "cancelled"
+    q.save()
+    return *job, nil
+}
+
+// Retry moves a failed or cancelled job to the end of the queue.
+func (q *Queue) Retry(id int) (Job, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    job := q.find(id)
+    if job == nil {
+        return Job{}, fmt.Errorf("job %v not found", id)
+    }
+    if job.Status != "failed" && job.Status != "cancelled" {
+        return *job, fmt.Errorf("job %v is %s; only failed or cancelled jobs can be retried", id, job.Status)
+    }
+    if other := q.active(job.Root, job.Title); other != nil {
+        return *job, fmt.Errorf("%s already has job %v", job.Title, other.Id)
+    }
+    job.Status = "pending"
+    job.Error = ""
+    job.Log = nil
+    job.Started = nil
+    job.Finished = nil
+    q.remove(job)
+    q.jobs = append(q.jobs, job)
+    q.save()
+    q.notify()
+    return *job, nil
+}
+
+// Move places a pending job at the supplied position among the pending jobs; 0 runs next.
+func (q *Queue) Move(id int, position int) (Job, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    job := q.find(id)
+    if job == nil {
+        return Job{}, fmt.Errorf("job %v not found", id)
+    }
+    if job.Status != "pending" {
+        return *job, fmt.Errorf("job %v is %s; only pending jobs can be moved", id, job.Status)
+    }
+    q.remove(job)
+    jobs := make([]*Job, 0, len(q.jobs) + 1)
+    pending := 0
+    inserted := false
+    for _, other := range q.jobs {
+        if !inserted && other.Status == "pending" {
+            if pending == position {
+                jobs = append(jobs, job)
+                inserted = true
+            }
+            pending++
+        }
+        jobs = append(jobs, other)
+    }
+    if !inserted {
+        jobs = append(jobs, job)
+    }
+    q.jobs = jobs
+    q.save()
+    return *job, nil
+}
+
+// Callers must hold the lock.
+func (q *Queue) remove(job *Job) {
+    for i, other := range q.jobs {
+        if other == job {
+            q.jobs = append(q.jobs[:i], q.jobs[i + 1:]...)
+            return
+        }
+    }
+}
+
+// Callers must hold the lock.
+func (q *Queue) notify() {
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+}
+
+// Callers must hold the lock.
+func (q *Queue) prune() {
+    finished := 0
+    for i := len(q.jobs) - 1; i >= 0; i-- {
+        job := q.jobs[i]
+        if job.Status == "pending" || job.Status == "running" {
+            continue
+        }
+        finished++
+        if finished > jobHistory {
+            q.jobs = append(q.jobs[:i], q.jobs[i + 1:]...)
+        }
+    }
+}
+
+// Takes the next pending job and marks it as running. Returns nil when nothing is pending.
+func (q *Queue) next() *Job {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    for _, job := range q.jobs {
+        if job.Status == "pending" {
+            now := time.Now()
+            job.Status = "running"
+            job.Started = &now
+            job.Attempts++
+            q.save()
+            return job
+        }
+    }
+    return nil
+}
+
+func (q *Queue) finish(job *Job, err error, sizeBefore int64, sizeAfter int64, log []string) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    now := time.Now()
+    job.Finished = &now
+    job.SizeBefore = sizeBefore
+    job.SizeAfter = sizeAfter
+    if job.Status == "cancelled" {
+        // What the job was doing when it was killed is not an error.
+        q.prune()
+        q.save()
+        return
+    }
+    job.Status = "done"
+    if err != nil {
+        job.Status = "failed"
+        job.Error = err.Error()
+        job.Log = log
+    }
+    q.prune()
+    q.save()
+}
+
+// Puts the running job back to pending, unless it was cancelled.
+func (q *Queue) requeue(job *Job) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+    if job.Status == "cancelled" {
+        now := time.Now()
+        job.Finished = &now
+        q.save()
+        return
+    }
+    job.Status = "pending"
+    job.Started = nil
+    q.save()
+}
+
+// Work runs pending jobs one at a time until interrupted. A job that is interrupted is put back
+// to pending so that the next daemon resumes it.
+func (q *Queue) Work() {
+    // The trash was pruned when the daemon started.
+    pruned := time.Now()
+    for !Interrupted() {
+        job := q.next()
+        if job == nil {
+            if time.Since(pruned) >= 24 * time.Hour {
+                for _, root := range GetParameters().Roots() {
+                    PruneTrash(root)
+                }
+                pruned = time.Now()
+            }
+            select {
+            case <-q.wake:
+            case <-interrupted:
+            case <-time.After(time.Hour):
+            }
+            continue
+        }
+        Infof("### Running job %v: %s %s.", job.Id, job.Kind, job.Title)
+        sizeBefore := titleSize(job.Root, job.Title)
+        StartProgress(job.Title)
+        err := q.run(job)
+        log := StopProgress()
+        if err != nil && Interrupted() {
+            q.requeue(job)
+            return
+        }
+        q.finish(job, err, sizeBefore, titleSize(job.Root, job.Title), log)
+        if done, _ := q.Job(job.Id); done.Status == "done" && job.Kind == "concat" && GetMedia(job.Root, job.Title) != nil {
+            // The joined movie still needs to be optimized; do that next.
+            if next, err := q.Enqueue("optimize", job.Root, job.Title); err == nil {
+                q.Move(next.Id, 0)
+            }
+        }
+    }
+}
+
+// Runs the job with commands of its own, which Cancel kills.
+func (q *Queue) run(job *Job) error {
+    ctx, cancel := context.WithCancel(commands)
+    q.lock.Lock()
+    q.cancel = cancel
+    if job.Status == "cancelled" {
+        // It was cancelled before it got this far.
+        cancel()
+    }
+    q.lock.Unlock()
+    SetJobCommands(ctx)
+    defer func() {
+        SetJobCommands(commands)
+        q.lock.Lock()
+        q.cancel = nil
+        q.lock.Unlock()
+        cancel()
+    }()
+    if job.Kind == "concat" {
+        return Concat(job.Root, job.Title)
+    }
+    m := GetMedia(job.Root, job.Title)
+    if m == nil {
+        return fmt.Errorf("no movie found for %s in %s", job.Title, job.Root)
+    }
+    _, err := Optimize(m)
+    return err
+}
+
+// Returns the size in bytes of the videos in the title's folder, ignoring the originals.
+func titleSize(root string, title string) int64 {
+    size := int64(0)
+    files, err := ioutil.ReadDir(filepath.Join(root, title))
+    if err != nil {
+        return size
+    }
+    for _, file := range files {
+        if !file.IsDir() && isVideoExtension(strings.TrimPrefix(filepath.Ext(file.Name()), ".")) {
+            size += file.Size()
+        }
+    }
+    return size
+}
+
+func validValue(values string, value string) bool {
+    return value != "" && strings.Contains(values, " " + value + " ")
+}
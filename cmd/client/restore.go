@@ -0,0 +1,302 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// The file the optimizer keeps the audio of the original video in.
+const OriginalAudio = "original_audio.mka"
+
+// The originals of a title. Files are found by their path relative to the title's folder, so an
+// original in the title's folder or a newer batch of the trash hides one with the same path in an
+// older batch. The sidecars of archived originals are kept apart so that none of them is hidden.
+type titleSources struct {
+    files    map[string]string
+    sidecars []string
+}
+
+// A file that is moved back into the title's folder.
+type restoreMove struct {
+    from string
+    to   string
+}
+
+// Restore puts the titles of the library root that match the filter back the way they were
+// before they were optimized, using the originals kept in their folders or in the trash.
+func Restore(root string, summary *Summary) {
+    entries, err := ioutil.ReadDir(root)
+    if err != nil {
+        Errorf("%v", err)
+        return
+    }
+    for _, entry := range entries {
+        if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !GetParameters().Matches(entry.Name()) {
+            continue
+        }
+        if Interrupted() {
+            return
+        }
+        sizeBefore := titleSize(root, entry.Name())
+        StartProgress(entry.Name())
+        err := restoreTitle(root, entry.Name())
+        if err != nil {
+            Errorf("Refusing to restore %s: %v", entry.Name(), err)
+            err = &StepError{entry.Name(), "restore", err}
+        }
+        StopProgress()
+        summary.Add(entry.Name(), "restored", true, err, sizeBefore, titleSize(root, entry.Name()))
+    }
+}
+
+func restoreTitle(root string, title string) error {
+    dir := filepath.Join(root, title)
+    sources := restoreSources(root, title)
+    moves := make([]restoreMove, 0)
+    remux := ""
+    for name, from := range sources.files {
+        // Undo the concatenation. The parts that were scaled before they were joined are put back
+        // from the originals kept by scale.
+        part := strings.TrimPrefix(name, "orig/")
+        if part == name || !origPartPattern.MatchString(part) {
+            continue
+        }
+        if unscaled, ok := sources.files[part + ".orig"]; ok {
+            from = unscaled
+        }
+        moves = append(moves, restoreMove{from, filepath.Join(dir, part)})
+    }
+    if len(moves) > 0 {
+        sort.Slice(moves, func(i, j int) bool {
+            return moves[i].to < moves[j].to
+        })
+    } else if original, name := findArchive(sources.sidecars, "video"); original != "" {
+        moves = append(moves, restoreMove{original, filepath.Join(dir, name)})
+    } else if original, name := findArchive(sources.sidecars, "audio"); original != "" {
+        moves = append(moves, restoreMove{original, filepath.Join(dir, name)})
+    } else if original, name := findSource(sources.files, title, ".orig"); original != "" {
+        moves = append(moves, restoreMove{original, filepath.Join(dir, name)})
+    } else if original, name := findSource(sources.files, title, ".audio.orig"); original != "" {
+        moves = append(moves, restoreMove{original, filepath.Join(dir, name)})
+    } else if audio, ok := sources.files[OriginalAudio]; ok {
+        remux = audio
+    }
+    current := GetMedia(root, title)
+    if len(moves) == 0 && (remux == "" || current == nil) {
+        return fmt.Errorf("its originals are gone")
+    }
+    if GetParameters().DryRun() {
+        for _, move := range moves {
+            Infof("Would restore %s to %s.", move.from, move.to)
+        }
+        if remux != "" {
+            Infof("Would restore the audio of %s from %s.", current.Video().Path(), remux)
+        }
+        return nil
+    }
+    Infof("### Restoring %s.", title)
+    batch := trashBatch(root)
+    if remux != "" {
+        Warnf("The original video is gone; only the original audio can be restored.")
+        attributes, err := readAttributes(current.Video().Path())
+        if err != nil {
+            return err
+        }
+        restored, err := remuxAudio(current.Video().Path(), remux, dir, title)
+        if err != nil {
+            return err
+        }
+        inheritAttributes(attributes, restored)
+        defer RemoveTmp(restored)
+        moves = append(moves, restoreMove{restored, filepath.Join(dir, title + ".mkv")})
+    }
+    // The optimized video makes way for the originals. It is set aside rather than deleted until
+    // they are back, so that it can be put back when one of them fails to move.
+    video, aside, size := "", "", int64(0)
+    if current != nil {
+        video = current.Video().Path()
+        if info, err := os.Stat(video); err == nil {
+            size = info.Size()
+        }
+        var err error
+        if aside, err = setAside(root, batch, video); err != nil {
+            return fmt.Errorf("unable to move %s out of the way: %v", video, err)
+        }
+    }
+    for i, move := range moves {
+        if err := Move(move.from, move.to); err != nil {
+            for j := i - 1; j >= 0; j-- {
+                Move(moves[j].to, moves[j].from)
+            }
+            if aside != "" {
+                Move(aside, video)
+            }
+            return fmt.Errorf("unable to move %s to %s: %v", move.from, move.to, err)
+        }
+    }
+    for _, move := range moves {
+        files, sizes := listFiles(move.to)
+        for i, file := range files {
+            audit("restored", filepath.Join(move.from, strings.TrimPrefix(file, move.to)), sizes[i], move.to)
+        }
+    }
+    if aside != "" {
+        action := "trashed"
+        if batch == "" {
+            action = "deleted"
+            Infof("Deleting %s.", video)
+            if err := os.Remove(aside); err != nil {
+                Errorf("Failed to discard %s: %v", aside, err)
+            }
+        }
+        audit(action, video, size, moves[0].to)
+    }
+    // What is left over belongs to the optimized title.
+    for _, leftover := range findOriginals(dir) {
+        discard(root, batch, leftover, "")
+    }
+    if PathExists(filepath.Join(dir, OriginalAudio)) {
+        discard(root, batch, filepath.Join(dir, OriginalAudio), "")
+    }
+    os.Remove(filepath.Join(dir, ReviewFile))
+    if trash := trashDir(root); trash != "" {
+        // Only removes the title's folders in the trash, and then their batches, once they are empty.
+        for _, entry := range trashEntries(trash) {
+            if entry.title == title && removeEmpty(entry.path(trash)) {
+                os.Remove(filepath.Join(trash, entry.batch))
+            }
+        }
+    }
+    Infof("Restored %s.", title)
+    return nil
+}
+
+// Moves the optimized video into the batch of the trash, keeping its path relative to the library
+// root, or next to itself when there is no batch. Returns where it was moved to.
+func setAside(root string, batch string, video string) (string, error) {
+    aside := video + ".restoring"
+    if batch != "" {
+        relative, err := filepath.Rel(root, video)
+        if err != nil {
+            return "", err
+        }
+        aside = filepath.Join(batch, relative)
+        if Mkdir(filepath.Dir(aside)) == "" {
+            return "", fmt.Errorf("unable to make %s", filepath.Dir(aside))
+        }
+        Infof("Moving %s to the trash.", video)
+    }
+    return aside, Move(video, aside)
+}
+
+// Returns the originals of the title. The contents of the orig folders are listed rather than the
+// folders, so that the orig folders of several batches of the trash are combined. Originals in
+// the title's folder come first, then those in the trash, newest first.
+func restoreSources(root string, title string) titleSources {
+    sources := titleSources{make(map[string]string), make([]string, 0)}
+    dir := filepath.Join(root, title)
+    sources.add(dir, append(findOriginals(dir), filepath.Join(dir, OriginalAudio)))
+    trash := trashDir(root)
+    if trash == "" {
+        return sources
+    }
+    entries := trashEntries(trash)
+    for i := len(entries) - 1; i >= 0; i-- {
+        if entries[i].title != title {
+            continue
+        }
+        files, err := ioutil.ReadDir(entries[i].path(trash))
+        if err != nil {
+            continue
+        }
+        paths := make([]string, 0)
+        for _, file := range files {
+            paths = append(paths, filepath.Join(entries[i].path(trash), file.Name()))
+        }
+        sources.add(entries[i].path(trash), paths)
+    }
+    return sources
+}
+
+// Adds the originals that are not among the sources yet, by their paths relative to the folder,
+// and the sidecars of the archived originals.
+func (s *titleSources) add(dir string, paths []string) {
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            continue
+        }
+        files := []string{path}
+        if info.IsDir() {
+            files, _ = listFiles(path)
+        }
+        for _, file := range files {
+            name, err := filepath.Rel(dir, file)
+            if err != nil {
+                continue
+            }
+            name = filepath.ToSlash(name)
+            if strings.HasPrefix(name, "orig/") && filepath.Ext(name) == ".json" {
+                s.sidecars = append(s.sidecars, file)
+            }
+            if _, ok := s.files[name]; !ok {
+                s.files[name] = file
+            }
+        }
+    }
+}
+
+// Returns where the oldest archived original replaced by the step is and the name it had in the
+// title's folder.
+func findArchive(sidecars []string, step string) (string, string) {
+    found, foundPath := Archive{}, ""
+    for _, sidecar := range sidecars {
+        archive, err := readArchive(sidecar)
+        path := filepath.Join(filepath.Dir(sidecar), archive.File)
+        if err != nil || archive.Step != step || !PathExists(path) {
+            continue
+        }
+        if foundPath == "" || archive.Archived.Before(found.Archived) {
+            found, foundPath = archive, path
+        }
+    }
+    return foundPath, found.Name
+}
+
+// Returns where the original of the title's video with the suffix is and its name without it.
+// Originals were kept this way before they were archived.
+func findSource(sources map[string]string, title string, suffix string) (string, string) {
+    for _, extension := range VideoExtensions {
+        name := title + "." + extension
+        if path, ok := sources[name + suffix]; ok {
+            return path, name
+        }
+    }
+    return "", ""
+}
+
+// Joins the video of the optimized title with its original audio. Returns the joined file.
+func remuxAudio(video string, audio string, dir string, title string) (string, error) {
+    restored := TrackTmp(filepath.Join(dir, title + ".mkv.tmp"))
+    params := []string{}
+    params = append(params, "-i", video)
+    params = append(params, "-i", audio)
+    params = append(params, "-map", "0:v")
+    params = append(params, "-map", "1:a")
+    params = append(params, "-c", "copy")
+    params = append(params, "-f", "matroska")
+    params = append(params, "-y")
+    params = append(params, restored)
+    PrintFfmpeg(params)
+    v := &Video{}
+    v.SetPath(video)
+    if err := RunFfmpeg(params, v.Seconds(), -1); err != nil {
+        RemoveTmp(restored)
+        return "", err
+    }
+    return restored, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ReviewFile is written to a title's folder when the optimized video needs a manual review. The
+// title's original videos are not discarded while the file exists.
+const ReviewFile = "quality-review.txt"
+
+// SceneReview records how a scene of the optimized video compares to the original video.
+type SceneReview struct {
+    Scene   int
+    Start   float64
+    End     float64
+    Frames  int
+    MinSsim float64
+    MinPsnr float64
+    Flagged bool
+}
+
+// Compares the optimized video to a cropped and scaled reference of the original video frame by
+// frame, and reports the lowest SSIM and PSNR of each scene. Returns the report and whether any
+// scene fell below the minimum SSIM or PSNR. A title is also flagged when it could not be
+// compared. The report is kept in the reports folder of the metadata directory.
+func reviewQuality(m *Media, segments []*Segment, original *Video, optimized string) (string, bool) {
+    ProgressStep("Reviewing quality")
+    report := fmt.Sprintf("Quality review of %s on %s.\n", m.Name(), time.Now().Format("2006-01-02 15:04"))
+    report = report + fmt.Sprintf("Scenes are flagged below an SSIM of %v or a PSNR of %v.\n\n", GetParameters().MinSsim(), GetParameters().MinPsnr())
+    reviews, err := measureScenes(segments, original, optimized)
+    flagged := err != nil
+    if err != nil {
+        report = report + fmt.Sprintf("The optimized video could not be compared to the original video: %v\n", err)
+    } else {
+        report = report + fmt.Sprintf("%-6s %-10s %-10s %-8s %-9s %-9s\n", "Scene", "Start", "End", "Frames", "Min SSIM", "Min PSNR")
+        for _, review := range reviews {
+            line := fmt.Sprintf("%-6v %-10v %-10v %-8v %-9.4f %-9.2f", review.Scene, timestamp(review.Start), timestamp(review.End), review.Frames, review.MinSsim, review.MinPsnr)
+            if review.Flagged {
+                line = line + " FLAGGED"
+                flagged = true
+            }
+            report = report + line + "\n"
+        }
+    }
+    if flagged {
+        Warnf("The quality of %s is below the minimum; the original videos will be kept for review.", m.Name())
+    } else {
+        Progressf("The quality of %s meets the minimum.", m.Name())
+    }
+    reports := Mkdir(filepath.Join(DefaultMetadataDir(), "reports"))
+    if reports != "" {
+        Write(filepath.Join(reports, m.Name() + ".txt"), report)
+    }
+    return report, flagged
+}
+
+// Runs ffmpeg's ssim and psnr filters with per-frame statistics files and groups the frames by
+// the scenes the video was encoded in.
+func measureScenes(segments []*Segment, original *Video, optimized string) ([]*SceneReview, error) {
+    o := &Video{}
+    o.SetPath(optimized)
+    if o.Width() == 0 || o.Height() == 0 {
+        return nil, fmt.Errorf("unable to probe %s", optimized)
+    }
+    // The statistics files are written to a temporary directory so that the title's name does not
+    // need to be escaped within the filter graph.
+    tmpDir := MkTmpDir()
+    if tmpDir == "" {
+        return nil, fmt.Errorf("unable to make a temporary directory")
+    }
+    defer RemoveTmp(tmpDir)
+    ssimStats := filepath.Join(tmpDir, "ssim.log")
+    psnrStats := filepath.Join(tmpDir, "psnr.log")
+    // The reference is the original video deinterlaced, cropped and scaled the same way the
+    // optimized video was, at the optimized video's frame rate.
+    reference := []string{}
+    if !GetParameters().Ultrafast() && GetParameters().Decomb() && !original.Progressive() {
+        reference = append(reference, "bwdif")
+    }
+    reference = append(reference, original.Crop().Filter())
+    reference = append(reference, fmt.Sprintf("scale=%v:%v:flags=bicubic", o.Width(), o.Height()))
+    reference = append(reference, "setsar=1")
+    reference = append(reference, "fps=" + o.Fps())
+    reference = append(reference, "format=yuv420p")
+    lavfi := ""
+    lavfi = lavfi + "[0:v]setsar=1,format=yuv420p,split[d1][d2];"
+    lavfi = lavfi + "[1:v]" + strings.Join(reference, ",") + ",split[r1][r2];"
+    lavfi = lavfi + fmt.Sprintf("[d1][r1]ssim=stats_file=%s;", ssimStats)
+    lavfi = lavfi + fmt.Sprintf("[d2][r2]psnr=stats_file=%s", psnrStats)
+    params := []string{}
+    params = append(params, "-i", optimized)
+    params = append(params, "-i", original.Path())
+    params = append(params, "-lavfi", lavfi)
+    params = append(params, "-f", "null")
+    params = append(params, "-")
+    if err := RunFfmpeg(params, o.Seconds(), -1); err != nil {
+        return nil, err
+    }
+    ssims := frameStats(ssimStats, "All")
+    psnrs := frameStats(psnrStats, "psnr_avg")
+    if len(ssims) == 0 || len(psnrs) == 0 {
+        return nil, fmt.Errorf("no ssim or psnr statistics were written")
+    }
+    reviews := make([]*SceneReview, 0)
+    for i, segment := range segments {
+        reviews = append(reviews, &SceneReview{Scene: i, Start: segment.Start, End: segment.End, MinSsim: 1, MinPsnr: 100})
+    }
+    fps := fpsValue(o.Fps())
+    for i, ssim := range ssims {
+        review := sceneAt(reviews, float64(i) / fps)
+        review.Frames++
+        if ssim < review.MinSsim {
+            review.MinSsim = ssim
+        }
+        if i < len(psnrs) && psnrs[i] < review.MinPsnr {
+            review.MinPsnr = psnrs[i]
+        }
+    }
+    for _, review := range reviews {
+        review.Flagged = review.Frames > 0 && !meetsReviewMinimum(review.MinSsim, review.MinPsnr)
+    }
+    return reviews, nil
+}
+
+func meetsReviewMinimum(ssim float64, psnr float64) bool {
+    if GetParameters().MinSsim() > 0 && ssim < GetParameters().MinSsim() {
+        return false
+    }
+    if GetParameters().MinPsnr() > 0 && psnr < GetParameters().MinPsnr() {
+        return false
+    }
+    return true
+}
+
+// Returns the scene that the time falls in. Frames past the last scene belong to the last scene.
+func sceneAt(reviews []*SceneReview, seconds float64) *SceneReview {
+    for _, review := range reviews {
+        if seconds < review.End {
+            return review
+        }
+    }
+    return reviews[len(reviews) - 1]
+}
+
+// Reads a statistics file written by the ssim or psnr filter, one line per frame of key:value
+// pairs, and returns the value of the key for each frame. A PSNR of inf is recorded as 100.
+func frameStats(target string, key string) []float64 {
+    values := make([]float64, 0)
+    if !PathExists(target) {
+        return values
+    }
+    for _, line := range Read(target) {
+        for _, field := range strings.Fields(line) {
+            pair := strings.SplitN(field, ":", 2)
+            if len(pair) != 2 || pair[0] != key {
+                continue
+            }
+            value := float64(100)
+            if pair[1] != "inf" {
+                value, _ = strconv.ParseFloat(pair[1], 64)
+            }
+            values = append(values, value)
+        }
+    }
+    return values
+}
+
+// Converts a frame rate such as 24000/1001 into frames per second.
+func fpsValue(fps string) float64 {
+    ratio := strings.Split(fps, "/")
+    left, _ := strconv.ParseFloat(ratio[0], 64)
+    right := float64(1)
+    if len(ratio) > 1 {
+        right, _ = strconv.ParseFloat(ratio[1], 64)
+    }
+    if left <= 0 || right <= 0 {
+        return 24
+    }
+    return left / right
+}
+
+// Formats seconds as h:mm:ss.
+func timestamp(seconds float64) string {
+    s := int(seconds)
+    return fmt.Sprintf("%d:%02d:%02d", s / 3600, (s % 3600) / 60, s % 60)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// The shortest a chunk is by default, in seconds, unless the video is too short to keep every
+// core busy.
+const defaultMinChunk = 300
+
+// SceneChange is a frame that differs from the frame before it by more than the scene threshold.
+type SceneChange struct {
+    Time  float64 `json:"time"`
+    Score float64 `json:"score"`
+}
+
+// Returns the scene changes of the video whose score is above the scene threshold. The scene
+// changes are recorded in scenes.json in the title's work directory with the threshold they were
+// detected with so that they are reused when the title is resumed.
+func sceneChanges(path string, v *Video) []SceneChange {
+    threshold := GetParameters().SceneThreshold()
+    record := filepath.Join(path, "scenes.json")
+    detected := struct {
+        Threshold float64       `json:"threshold"`
+        Changes   []SceneChange `json:"changes"`
+    }{}
+    if data, err := os.ReadFile(record); err == nil && json.Unmarshal(data, &detected) == nil && detected.Threshold == threshold {
+        return detected.Changes
+    }
+    changes, err := detectScenes(v, threshold)
+    if err != nil {
+        Warnf("Failed to detect scenes: %v", err)
+        return changes
+    }
+    detected.Threshold = threshold
+    detected.Changes = changes
+    data, _ := json.MarshalIndent(detected, "", "  ")
+    Write(record, string(data))
+    return changes
+}
+
+// Runs ffprobe over the video with the scene filter and reads the time and score of each frame
+// the filter selects from its json output.
+func detectScenes(v *Video, threshold float64) ([]SceneChange, error) {
+    ProgressStep("Detecting scenes")
+    graph := fmt.Sprintf("movie=%s,select=gt(scene\\,%s)", escapeFilterGraph(escapeFilterOption(v.Path())), strconv.FormatFloat(threshold, 'f', -1, 64))
+    stdout, err := Ffprobe(
+        "-f", "lavfi",
+        "-i", graph,
+        "-show_entries", "frame=best_effort_timestamp_time:frame_tags=lavfi.scene_score",
+        "-of", "json")
+    if err != nil {
+        return nil, err
+    }
+    return parseScenes(stdout)
+}
+
+func parseScenes(data []byte) ([]SceneChange, error) {
+    probe := struct {
+        Frames []struct {
+            Time string            `json:"best_effort_timestamp_time"`
+            Tags map[string]string `json:"tags"`
+        } `json:"frames"`
+    }{}
+    if err := json.Unmarshal(data, &probe); err != nil {
+        return nil, err
+    }
+    changes := make([]SceneChange, 0)
+    for _, frame := range probe.Frames {
+        time, err := strconv.ParseFloat(frame.Time, 64)
+        if err != nil {
+            continue
+        }
+        score, _ := strconv.ParseFloat(frame.Tags["lavfi.scene_score"], 64)
+        changes = append(changes, SceneChange{time, score})
+    }
+    sort.Slice(changes, func(i, j int) bool {
+        return changes[i].Time < changes[j].Time
+    })
+    return changes, nil
+}
+
+// Returns the times the video should be split at. Scene changes closer to the previous split than
+// the minimum chunk length are skipped. Chunks longer than the maximum chunk length are split
+// evenly, and when there are more chunks than the maximum chunk count the shortest chunks are
+// merged into their neighbours.
+func sceneTimes(path string, v *Video) []float64 {
+    return planChunks(sceneChanges(path, v), v.Seconds())
+}
+
+func planChunks(changes []SceneChange, duration float64) []float64 {
+    p := GetParameters()
+    minChunk := p.MinChunk(duration)
+    times := make([]float64, 0)
+    prevTime := float64(0)
+    for _, change := range changes {
+        if change.Time - prevTime > minChunk && change.Time < duration {
+            times = append(times, change.Time)
+            prevTime = change.Time
+        }
+    }
+    if p.MaxChunk() > 0 {
+        split := make([]float64, 0)
+        start := float64(0)
+        for _, end := range append(times, duration) {
+            parts := math.Ceil((end - start) / p.MaxChunk())
+            for i := 1; i < int(parts); i++ {
+                split = append(split, start + (end - start) * float64(i) / parts)
+            }
+            if end < duration {
+                split = append(split, end)
+            }
+            start = end
+        }
+        times = split
+    }
+    for p.MaxChunks() > 0 && len(times) + 1 > p.MaxChunks() {
+        // Remove the split whose neighbouring chunks are the shortest when merged.
+        shortest := 0
+        for i := range times {
+            if chunkEnd(times, i + 1, duration) - chunkStart(times, i) < chunkEnd(times, shortest + 1, duration) - chunkStart(times, shortest) {
+                shortest = i
+            }
+        }
+        times = append(times[:shortest], times[shortest + 1:]...)
+    }
+    return times
+}
+
+func chunkStart(times []float64, i int) float64 {
+    if i == 0 {
+        return 0
+    }
+    return times[i - 1]
+}
+
+func chunkEnd(times []float64, i int, duration float64) float64 {
+    if i >= len(times) {
+        return duration
+    }
+    return times[i]
+}
+
+// Prints the chunks the video would be split into.
+func printChunkPlan(m *Media) {
+    path := workDir(m.Name())
+    if path != "" && !PathExists(path) {
+        Mkdir(path)
+    }
+    duration := m.Video().Seconds()
+    times := sceneTimes(path, m.Video())
+    Infof("Chunk plan: %v chunks.", len(times) + 1)
+    for i := 0; i <= len(times); i++ {
+        start := chunkStart(times, i)
+        end := chunkEnd(times, i, duration)
+        Logf(LevelInfo, i, "%s - %s (%.1f seconds)", timestamp(start), timestamp(end), end - start)
+    }
+}
+
+// Escapes a value of a filter option, such as a file name, so that the filter reads it as is.
+func escapeFilterOption(value string) string {
+    return escapeChars(value, `\':`)
+}
+
+// Escapes a filter description so that the filter graph parser passes it to the filter as is.
+func escapeFilterGraph(value string) string {
+    return escapeChars(value, `\'[],;`)
+}
+
+func escapeChars(value string, chars string) string {
+    escaped := strings.Builder{}
+    for _, r := range value {
+        if strings.ContainsRune(chars, r) {
+            escaped.WriteRune('\\')
+        }
+        escaped.WriteRune(r)
+    }
+    return escaped.String()
+}
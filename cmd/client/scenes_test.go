@@ -0,0 +1,48 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+    p := testParameters()
+    minChunk, maxChunk, maxChunks := p.minChunk, p.maxChunk, p.maxChunks
+    defer func() {
+        p.minChunk, p.maxChunk, p.maxChunks = minChunk, maxChunk, maxChunks
+    }()
+    tests := []struct {
+        description string
+        minChunk    int
+        maxChunk    int
+        maxChunks   int
+        changes     []float64
+        duration    float64
+        times       []float64
+    }{
+        {"short scenes merged", 10, 0, 0, []float64{5, 12, 18, 30}, 40, []float64{12, 30}},
+        {"long scenes split evenly", 1, 10, 0, []float64{25}, 40, []float64{25.0 / 3, 50.0 / 3, 25, 32.5}},
+        {"the count capped", 1, 0, 3, []float64{10, 12, 30, 50}, 60, []float64{30, 50}},
+        {"a scene longer than the video", 1, 0, 0, []float64{120}, 100, []float64{}},
+        {"a scene longer than the video split evenly", 1, 40, 0, []float64{120}, 100, []float64{100.0 / 3, 200.0 / 3}},
+        {"no scene changes", 1, 0, 0, []float64{}, 100, []float64{}},
+    }
+    for _, test := range tests {
+        p.minChunk, p.maxChunk, p.maxChunks = test.minChunk, test.maxChunk, test.maxChunks
+        changes := make([]SceneChange, 0)
+        for _, change := range test.changes {
+            changes = append(changes, SceneChange{Time: change})
+        }
+        times := planChunks(changes, test.duration)
+        if len(times) != len(test.times) {
+            t.Errorf("%s: split at %v; want %v", test.description, times, test.times)
+            continue
+        }
+        for i := range times {
+            if math.Abs(times[i] - test.times[i]) > 0.001 {
+                t.Errorf("%s: split at %v; want %v", test.description, times, test.times)
+                break
+            }
+        }
+    }
+}
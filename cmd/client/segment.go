@@ -0,0 +1,95 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// Segment is a part of a video that was split losslessly at a keyframe.
+type Segment struct {
+    Path  string
+    Start float64
+    End   float64
+}
+
+func (s *Segment) Seconds() float64 {
+    return s.End - s.Start
+}
+
+// Splits the video in the work directory at the keyframes nearest to the detected scene changes
+// in a single pass without re-encoding it, so that the frames of the scenes neither overlap nor
+// leave gaps. The split is reused when the title is resumed.
+func segmentVideo(path string, v *Video) ([]*Segment, error) {
+    list := filepath.Join(path, "segments.csv")
+    if segments, err := readSegments(list); err == nil {
+        return segments, nil
+    }
+    ProgressStep("Splitting scenes")
+    times := []string{}
+    for _, time := range sceneTimes(path, v) {
+        times = append(times, strconv.FormatFloat(time, 'f', 6, 64))
+    }
+    // The segment muxer replaces %d with the number of the segment.
+    pattern := strings.ReplaceAll(v.Path(), "%", "%%") + ".seg%d.mkv"
+    params := []string{}
+    params = append(params, "-i", v.Path())
+    params = append(params, "-map", "0:v:0")
+    params = append(params, "-map", "0:a?")
+    params = append(params, "-c", "copy")
+    params = append(params, "-f", "segment")
+    if len(times) > 0 {
+        params = append(params, "-segment_times", strings.Join(times, ","))
+    } else {
+        // Keep the video in one segment.
+        params = append(params, "-segment_time", strconv.Itoa(int(v.Seconds()) + 1))
+    }
+    params = append(params, "-segment_format", "matroska")
+    params = append(params, "-segment_list", list + ".tmp")
+    params = append(params, "-segment_list_type", "csv")
+    params = append(params, "-reset_timestamps", "1")
+    params = append(params, "-y")
+    params = append(params, pattern)
+    if err := RunFfmpeg(params, v.Seconds(), -1); err != nil {
+        return nil, err
+    }
+    if err := os.Rename(list + ".tmp", list); err != nil {
+        return nil, err
+    }
+    return readSegments(list)
+}
+
+// Reads the csv segment list written by the segment muxer. Each line holds the file name, start
+// time and end time of a segment.
+func readSegments(list string) ([]*Segment, error) {
+    file, err := os.Open(list)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    records, err := csv.NewReader(file).ReadAll()
+    if err != nil {
+        return nil, err
+    }
+    segments := make([]*Segment, 0)
+    for _, record := range records {
+        if len(record) < 3 {
+            return nil, fmt.Errorf("illegal segment: %v", record)
+        }
+        segment := &Segment{}
+        segment.Path = filepath.Join(filepath.Dir(list), filepath.Base(record[0]))
+        segment.Start, _ = strconv.ParseFloat(record[1], 64)
+        segment.End, _ = strconv.ParseFloat(record[2], 64)
+        if !PathExists(segment.Path) {
+            return nil, fmt.Errorf("missing segment: %s", segment.Path)
+        }
+        segments = append(segments, segment)
+    }
+    if len(segments) == 0 {
+        return nil, fmt.Errorf("no segments in %s", list)
+    }
+    return segments, nil
+}
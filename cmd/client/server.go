@@ -0,0 +1,222 @@
+package main
+
+import (
+    _ "embed"
+    "encoding/json"
+    "fmt"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+//go:embed dashboard.html
+var dashboard []byte
+
+type enqueueRequest struct {
+    Kind  string `json:"kind"`
+    Root  string `json:"root"`
+    Title string `json:"title"`
+}
+
+type moveRequest struct {
+    Position int `json:"position"`
+}
+
+type apiError struct {
+    Error string `json:"error"`
+}
+
+// Daemon runs the persistent job queue and serves its HTTP API. When watch is true the library
+// roots are also watched and settled titles are added to the queue. The daemon stops once the
+// running job has finished after an interrupt.
+func Daemon(watch bool) {
+    q, err := LoadQueue()
+    if err != nil {
+        Fatalf("%v", err)
+    }
+    watched := make(chan struct{})
+    if watch {
+        go func() {
+            Watch(GetParameters().Roots(), q)
+            close(watched)
+        }()
+    } else {
+        close(watched)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", guard(handleDashboard))
+    mux.HandleFunc("/status", guard(handleStatus))
+    mux.HandleFunc("/jobs", guard(q.handleJobs))
+    mux.HandleFunc("/jobs/", guard(q.handleJob))
+    Infof("### Serving the job queue and dashboard on http://%s.", GetParameters().Api())
+    go func() {
+        Fatalf("%v", http.ListenAndServe(GetParameters().Api(), mux))
+    }()
+    q.Work()
+    <-watched
+    Infof("### Stopped the job queue.")
+}
+
+// Refuses the requests a web page could have made: those naming a host other than the API
+// address, as DNS rebinding does to get around the loopback bind, those from a page of another
+// origin, and POSTs that are not JSON, which a page can send without a CORS preflight.
+func guard(handler http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !apiHost(r.Host) {
+            writeError(w, http.StatusForbidden, fmt.Errorf("illegal host: %s", r.Host))
+            return
+        }
+        if origin := r.Header.Get("Origin"); origin != "" {
+            parsed, err := url.Parse(origin)
+            if err != nil || parsed.Scheme != "http" || !apiHost(parsed.Host) {
+                writeError(w, http.StatusForbidden, fmt.Errorf("illegal origin: %s", origin))
+                return
+            }
+        }
+        if r.Method != http.MethodGet {
+            mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+            if err != nil || mediaType != "application/json" {
+                writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("the content type must be application/json"))
+                return
+            }
+        }
+        handler(w, r)
+    }
+}
+
+// Returns true when the host is the API address, or localhost or an IP address on its port. Only
+// a host name can be rebound to the loopback address.
+func apiHost(host string) bool {
+    if host == GetParameters().Api() {
+        return true
+    }
+    name, port, err := net.SplitHostPort(host)
+    if err != nil {
+        return false
+    }
+    _, apiPort, err := net.SplitHostPort(GetParameters().Api())
+    if err != nil || port != apiPort {
+        return false
+    }
+    return name == "localhost" || net.ParseIP(name) != nil
+}
+
+// GET / serves the dashboard.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write(dashboard)
+}
+
+// GET /status shows the progress of the title being processed; null when idle.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+    writeJson(w, http.StatusOK, CurrentProgress())
+}
+
+// GET /jobs?status=pending lists jobs. POST /jobs enqueues a title.
+func (q *Queue) handleJobs(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        status := r.URL.Query().Get("status")
+        if status != "" && !validValue(JobStatuses, status) {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("illegal job status: %s", status))
+            return
+        }
+        writeJson(w, http.StatusOK, q.Jobs(status))
+    case http.MethodPost:
+        request := enqueueRequest{}
+        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+            writeError(w, http.StatusBadRequest, err)
+            return
+        }
+        if request.Title == "" {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("a title is required"))
+            return
+        }
+        // Without a root the title is looked up in the daemon's own library roots.
+        if request.Root == "" {
+            for _, root := range GetParameters().Roots() {
+                if GetMedia(root, request.Title) != nil || hasParts(filepath.Join(root, request.Title)) {
+                    request.Root = root
+                    break
+                }
+            }
+            if request.Root == "" {
+                writeError(w, http.StatusBadRequest, fmt.Errorf("no movie found for %s", request.Title))
+                return
+            }
+        }
+        job, err := q.Enqueue(request.Kind, request.Root, request.Title)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, err)
+            return
+        }
+        writeJson(w, http.StatusOK, job)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+    }
+}
+
+// GET /jobs/{id} shows a job. POST /jobs/{id}/cancel, /jobs/{id}/retry and /jobs/{id}/move
+// change it.
+func (q *Queue) handleJob(w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+    id, err := strconv.Atoi(parts[0])
+    if err != nil {
+        writeError(w, http.StatusNotFound, fmt.Errorf("illegal job id: %s", parts[0]))
+        return
+    }
+    action := ""
+    if len(parts) > 1 {
+        action = parts[1]
+    }
+    if (action == "" && r.Method != http.MethodGet) || (action != "" && r.Method != http.MethodPost) {
+        writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+        return
+    }
+    if _, err := q.Job(id); err != nil {
+        writeError(w, http.StatusNotFound, err)
+        return
+    }
+    job := Job{}
+    switch action {
+    case "":
+        job, err = q.Job(id)
+    case "cancel":
+        job, err = q.Cancel(id)
+    case "retry":
+        job, err = q.Retry(id)
+    case "move":
+        request := moveRequest{}
+        if err = json.NewDecoder(r.Body).Decode(&request); err != nil {
+            writeError(w, http.StatusBadRequest, err)
+            return
+        }
+        job, err = q.Move(id, request.Position)
+    default:
+        writeError(w, http.StatusNotFound, fmt.Errorf("unknown action: %s", action))
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusConflict, err)
+        return
+    }
+    writeJson(w, http.StatusOK, job)
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+    writeJson(w, status, apiError{err.Error()})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "os"
+    "os/exec"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Closed by the first interrupt. No new chunks, titles or jobs are started once it is closed.
+var interrupted = make(chan struct{})
+
+// The context of every external command. The second interrupt cancels it, which kills them.
+var commands, killCommands = context.WithCancel(context.Background())
+
+// The context of the external commands of the running job. Cancelling the job cancels it, which
+// kills them. It is made from commands, so the second interrupt kills them as well.
+var jobLock sync.Mutex
+var jobCommands = commands
+
+// The temporary files and directories that are removed when the commands are killed.
+var tmpLock sync.Mutex
+var tmpPaths = make(map[string]bool)
+
+// HandleSignals stops gracefully on the first SIGINT or SIGTERM: the running chunks are finished
+// but no new ones are started, so that the next run resumes the title. The second signal kills
+// the running commands, removes the temporary files and exits.
+func HandleSignals() {
+    signals := make(chan os.Signal, 2)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-signals
+        Warnf("### Interrupted. Finishing the running scenes; interrupt again to stop them now.")
+        close(interrupted)
+        <-signals
+        Warnf("### Stopping the running commands.")
+        killCommands()
+        // Give the commands a moment to exit before their files are removed.
+        time.Sleep(time.Second)
+        tmpLock.Lock()
+        for path := range tmpPaths {
+            os.RemoveAll(path)
+        }
+        tmpLock.Unlock()
+        os.Exit(130)
+    }()
+}
+
+// Interrupted returns true once the first interrupt has been received.
+func Interrupted() bool {
+    select {
+    case <-interrupted:
+        return true
+    default:
+        return false
+    }
+}
+
+// SetJobCommands sets the context that ffmpeg and ffprobe are started with until it is set again.
+func SetJobCommands(ctx context.Context) {
+    jobLock.Lock()
+    defer jobLock.Unlock()
+    jobCommands = ctx
+}
+
+func jobContext() context.Context {
+    jobLock.Lock()
+    defer jobLock.Unlock()
+    return jobCommands
+}
+
+// Killed returns true once the running commands have been killed by the second interrupt.
+func Killed() bool {
+    return commands.Err() != nil
+}
+
+// Waits for the delay, the first interrupt or the running job being cancelled, whichever comes
+// first. Returns false when interrupted or cancelled.
+func Sleep(delay time.Duration) bool {
+    select {
+    case <-time.After(delay):
+        return true
+    case <-interrupted:
+        return false
+    case <-jobContext().Done():
+        return false
+    }
+}
+
+// TrackTmp registers a temporary file or directory to be removed when the commands are killed.
+func TrackTmp(path string) string {
+    tmpLock.Lock()
+    defer tmpLock.Unlock()
+    tmpPaths[path] = true
+    return path
+}
+
+// RemoveTmp removes a temporary file or directory registered with TrackTmp.
+func RemoveTmp(path string) {
+    tmpLock.Lock()
+    defer tmpLock.Unlock()
+    delete(tmpPaths, path)
+    os.RemoveAll(path)
+}
+
+// Returns the command for the program. It is started in its own process group, which is killed
+// when the context is done.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+    cmd := exec.CommandContext(ctx, name, args...)
+    detach(cmd)
+    cmd.Cancel = func() error {
+        return kill(cmd)
+    }
+    return cmd
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// Starts the command in its own process group, so that an interrupt typed in the terminal only
+// reaches this program, which decides when to stop it.
+func detach(cmd *exec.Cmd) {
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kills the command and anything it started, which share its process group.
+func kill(cmd *exec.Cmd) error {
+    return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
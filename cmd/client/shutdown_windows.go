@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// Starts the command in a new process group, which Ctrl+C typed in the console does not reach.
+func detach(cmd *exec.Cmd) {
+    cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+func kill(cmd *exec.Cmd) error {
+    return cmd.Process.Kill()
+}
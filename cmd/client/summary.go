@@ -0,0 +1,118 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "text/tabwriter"
+)
+
+// The results a title can have, in the order they are totalled.
+const summaryResults = " optimized concatenated restored planned skipped failed interrupted "
+
+// TitleResult records what a run did to a title.
+type TitleResult struct {
+    Title      string
+    Result     string
+    Err        error
+    SizeBefore int64
+    SizeAfter  int64
+}
+
+// Summary collects the result of every title processed by a run.
+type Summary struct {
+    results []TitleResult
+}
+
+// Add records the result of a title. Changed is false when there was nothing to do.
+func (s *Summary) Add(title string, action string, changed bool, err error, sizeBefore int64, sizeAfter int64) {
+    result := action
+    switch {
+    case errors.Is(err, ErrInterrupted):
+        result = "interrupted"
+    case err != nil:
+        result = "failed"
+    case !changed:
+        result = "skipped"
+    case GetParameters().DryRun():
+        result = "planned"
+    }
+    s.results = append(s.results, TitleResult{title, result, err, sizeBefore, sizeAfter})
+}
+
+// Failed returns true when any title failed.
+func (s *Summary) Failed() bool {
+    for _, result := range s.results {
+        if result.Result == "failed" {
+            return true
+        }
+    }
+    return false
+}
+
+// Print writes a table of the results and the bytes saved in total. The table is only written
+// with the text log format; otherwise each result is logged on its own line.
+func (s *Summary) Print() {
+    if len(s.results) == 0 {
+        return
+    }
+    counts := make(map[string]int)
+    saved := int64(0)
+    table := GetParameters().LogFormat() == "text"
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    if table {
+        fmt.Fprintln(w, "TITLE\tRESULT\tBEFORE\tAFTER\tSAVED\tDETAIL")
+    }
+    for _, result := range s.results {
+        counts[result.Result]++
+        detail, before, after, difference := "", "", "", ""
+        if result.Err != nil {
+            // Command failures end with the last lines of their log, which do not fit a table.
+            detail = strings.SplitN(result.Err.Error(), "\n", 2)[0]
+        } else if result.Result == "skipped" {
+            detail = "already optimal"
+        }
+        if result.Result == "optimized" || result.Result == "concatenated" || result.Result == "restored" {
+            before, after = formatBytes(result.SizeBefore), formatBytes(result.SizeAfter)
+            difference = formatBytes(result.SizeBefore - result.SizeAfter)
+            saved += result.SizeBefore - result.SizeAfter
+        }
+        if table {
+            fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Title, result.Result, before, after, difference, detail)
+        } else if before != "" {
+            Infof("%s: %s from %s to %s, saving %s.", result.Title, result.Result, before, after, difference)
+        } else if detail != "" {
+            Infof("%s: %s: %s.", result.Title, result.Result, detail)
+        } else {
+            Infof("%s: %s.", result.Title, result.Result)
+        }
+    }
+    w.Flush()
+    totals := make([]string, 0)
+    for _, result := range strings.Fields(summaryResults) {
+        if counts[result] > 0 {
+            totals = append(totals, fmt.Sprintf("%v %s", counts[result], result))
+        }
+    }
+    Infof("%s. Saved %s.", strings.Join(totals, ", "), formatBytes(saved))
+}
+
+// Returns the size in the largest unit that keeps it at 1 or more.
+func formatBytes(bytes int64) string {
+    units := []string{"B", "KB", "MB", "GB", "TB"}
+    size := float64(bytes)
+    negative := size < 0
+    if negative {
+        size = -size
+    }
+    i := 0
+    for size >= 1024 && i < len(units) - 1 {
+        size /= 1024
+        i++
+    }
+    if negative {
+        size = -size
+    }
+    return fmt.Sprintf("%.1f %s", size, units[i])
+}
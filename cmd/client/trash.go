@@ -0,0 +1,207 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "text/tabwriter"
+    "time"
+)
+
+// Each cleanup moves its originals into a batch of the trash named after when it ran.
+const trashBatchLayout = "20060102-150405"
+
+// A title's originals in one batch of the trash.
+type trashEntry struct {
+    batch   string
+    trashed time.Time
+    title   string
+    files   int
+    size    int64
+}
+
+func (e trashEntry) path(trash string) string {
+    return filepath.Join(trash, e.batch, e.title)
+}
+
+// Returns the trash of the library root, or an empty string when originals are deleted instead.
+// A relative trash is kept inside each root. An absolute trash is shared by the roots, each in a
+// folder named after the root and a hash of its path, such as "Movies-1a2b3c4d", so that roots
+// with the same name do not share one.
+func trashDir(root string) string {
+    trash := GetParameters().Trash()
+    if trash == "" {
+        return ""
+    }
+    if filepath.IsAbs(trash) {
+        path, err := filepath.Abs(root)
+        if err != nil {
+            path = filepath.Clean(root)
+        }
+        hash := fnv.New32a()
+        hash.Write([]byte(path))
+        return filepath.Join(trash, fmt.Sprintf("%s-%08x", filepath.Base(path), hash.Sum32()))
+    }
+    return filepath.Join(root, trash)
+}
+
+// Returns the batch of the trash that the originals of a cleanup starting now are moved into,
+// or an empty string when originals are deleted instead.
+func trashBatch(root string) string {
+    trash := trashDir(root)
+    if trash == "" {
+        return ""
+    }
+    return filepath.Join(trash, time.Now().Format(trashBatchLayout))
+}
+
+// Returns the titles in the trash, oldest first.
+func trashEntries(trash string) []trashEntry {
+    entries := make([]trashEntry, 0)
+    batches, err := ioutil.ReadDir(trash)
+    if err != nil {
+        return entries
+    }
+    for _, batch := range batches {
+        trashed, err := time.ParseInLocation(trashBatchLayout, batch.Name(), time.Local)
+        if !batch.IsDir() || err != nil {
+            continue
+        }
+        titles, err := ioutil.ReadDir(filepath.Join(trash, batch.Name()))
+        if err != nil {
+            continue
+        }
+        for _, title := range titles {
+            entry := trashEntry{batch.Name(), trashed, title.Name(), 0, 0}
+            files, sizes := listFiles(entry.path(trash))
+            entry.files = len(files)
+            for _, size := range sizes {
+                entry.size += size
+            }
+            entries = append(entries, entry)
+        }
+    }
+    sort.SliceStable(entries, func(i, j int) bool {
+        return entries[i].trashed.Before(entries[j].trashed)
+    })
+    return entries
+}
+
+// Removes the folder and the folders in it once they are empty, deepest first. Returns true when
+// the folder was removed.
+func removeEmpty(path string) bool {
+    dirs := make([]string, 0)
+    filepath.Walk(path, func(dir string, info os.FileInfo, err error) error {
+        if err == nil && info.IsDir() {
+            dirs = append(dirs, dir)
+        }
+        return nil
+    })
+    for i := len(dirs) - 1; i >= 0; i-- {
+        os.Remove(dirs[i])
+    }
+    return !PathExists(path)
+}
+
+// PruneTrash prunes the trash of the library root. It is done when this application starts, and
+// daily while a daemon is idle, so that a library with nothing left to clean up is pruned as well.
+func PruneTrash(root string) {
+    trash := trashDir(root)
+    if trash == "" || GetParameters().DryRun() || !PathExists(trash) {
+        return
+    }
+    pruneTrash(trash)
+}
+
+// Purges the originals that have been in the trash for longer than the retention period, then
+// the oldest ones until the trash fits in its size cap.
+func pruneTrash(trash string) {
+    entries := trashEntries(trash)
+    total := int64(0)
+    for _, entry := range entries {
+        total += entry.size
+    }
+    retention := GetParameters().TrashRetention()
+    limit := GetParameters().TrashSize()
+    for _, entry := range entries {
+        if retention > 0 && time.Since(entry.trashed) > retention {
+            purgeEntry(trash, entry, "it is older than the retention period")
+        } else if limit > 0 && total > limit {
+            purgeEntry(trash, entry, "the trash is over its size cap")
+        } else {
+            continue
+        }
+        total -= entry.size
+    }
+}
+
+// Removes a title's originals from a batch of the trash and records each file in the audit file.
+func purgeEntry(trash string, entry trashEntry, reason string) {
+    path := entry.path(trash)
+    Infof("Purging %s from the trash because %s.", path, reason)
+    files, sizes := listFiles(path)
+    if err := os.RemoveAll(path); err != nil {
+        Errorf("Failed to purge %s: %v", path, err)
+    }
+    for i, file := range files {
+        if !PathExists(file) {
+            audit("purged", file, sizes[i], "")
+        }
+    }
+    // Only removes the batch once it is empty.
+    os.Remove(filepath.Join(trash, entry.batch))
+}
+
+// PurgeTrash purges the originals of the titles that match the filter from the trash of the
+// library root once they are older than the retention period, or all of them with -all.
+func PurgeTrash(root string) {
+    trash := trashDir(root)
+    if trash == "" {
+        Warnf("There is no trash to purge; supply -trash to keep originals in one.")
+        return
+    }
+    retention := GetParameters().TrashRetention()
+    if !GetParameters().All() && retention == 0 {
+        Warnf("Originals are kept in the trash until they are purged; supply -all to purge them.")
+        return
+    }
+    for _, entry := range trashEntries(trash) {
+        if !GetParameters().Matches(entry.title) {
+            continue
+        }
+        if !GetParameters().All() && time.Since(entry.trashed) <= retention {
+            continue
+        }
+        if GetParameters().DryRun() {
+            Infof("Would purge %s (%s).", entry.path(trash), formatBytes(entry.size))
+            continue
+        }
+        purgeEntry(trash, entry, "it was asked to")
+    }
+}
+
+// PrintTrash lists the titles in the trash of the library root that match the filter, and how
+// much space they take up.
+func PrintTrash(root string) {
+    trash := trashDir(root)
+    if trash == "" {
+        Warnf("There is no trash to list; supply -trash to keep originals in one.")
+        return
+    }
+    files, total := 0, int64(0)
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "TRASHED\tTITLE\tFILES\tSIZE")
+    for _, entry := range trashEntries(trash) {
+        if !GetParameters().Matches(entry.title) {
+            continue
+        }
+        files += entry.files
+        total += entry.size
+        fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", entry.trashed.Format("2006-01-02 15:04:05"), entry.title, entry.files, formatBytes(entry.size))
+    }
+    fmt.Fprintf(w, "TOTAL\t%s\t%v\t%s\n", trash, files, formatBytes(total))
+    w.Flush()
+}
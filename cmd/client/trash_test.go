@@ -0,0 +1,70 @@
+package main
+
+import (
+    "encoding/json"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Archives a file as the original of the title's video, the way archiveOriginal does, and moves
+// the orig folder into the batch of the trash.
+func trashOriginal(t *testing.T, root string, title string, batch string, content string, archived time.Time) {
+    dir := filepath.Join(root, title, "orig")
+    if Mkdir(dir) == "" {
+        t.Fatalf("unable to make %s", dir)
+    }
+    archive := Archive{}
+    archive.Title = title
+    archive.File = title + " - 8500kbps.mkv"
+    archive.Name = title + ".mkv"
+    archive.Extension = "mkv"
+    archive.Step = "video"
+    archive.Archived = archived
+    data, _ := json.Marshal(archive)
+    if !Write(filepath.Join(dir, title + " - 8500kbps.json"), string(data)) || !Write(filepath.Join(dir, archive.File), content) {
+        t.Fatalf("unable to archive %s", content)
+    }
+    discard(root, batch, dir, "")
+}
+
+func TestRestoreFromTwoBatches(t *testing.T) {
+    testParameters()
+    t.Setenv("HOME", t.TempDir())
+    trash := GetParameters().trash
+    GetParameters().trash = ".trash"
+    defer func() {
+        GetParameters().trash = trash
+    }()
+    root := t.TempDir()
+    title := "Title (2000)"
+    // Both batches keep an orig folder with the same names, as when a title is optimized again.
+    older := filepath.Join(trashDir(root), "20200101-000000")
+    newer := filepath.Join(trashDir(root), "20200102-000000")
+    trashOriginal(t, root, title, older, "the original", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+    trashOriginal(t, root, title, newer, "the first optimized video", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+    for _, batch := range []string{older, newer} {
+        if path := filepath.Join(batch, title, "orig", title + " - 8500kbps.mkv"); !PathExists(path) {
+            t.Fatalf("the trash did not keep the relative path of %s", path)
+        }
+    }
+    if PathExists(filepath.Join(root, title, "orig")) {
+        t.Fatalf("the orig folder was not moved to the trash")
+    }
+    if !Write(filepath.Join(root, title, title + ".mp4"), "the optimized video") {
+        t.Fatalf("unable to write the optimized video")
+    }
+    if err := restoreTitle(root, title); err != nil {
+        t.Fatalf("unable to restore %s: %v", title, err)
+    }
+    restored := Read(filepath.Join(root, title, title + ".mkv"))
+    if len(restored) != 1 || restored[0] != "the original" {
+        t.Errorf("restored %q; want the original from the older batch", restored)
+    }
+    if PathExists(filepath.Join(root, title, title + ".mp4")) {
+        t.Errorf("the optimized video was not moved to the trash")
+    }
+    if !PathExists(filepath.Join(newer, title, "orig", title + " - 8500kbps.mkv")) {
+        t.Errorf("the newer batch lost its original")
+    }
+}
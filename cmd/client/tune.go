@@ -0,0 +1,177 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+)
+
+var TuneValues string = " none auto film digital grain animation "
+
+// The number of places in the video that are sampled to classify its content.
+const tuneSamples = 9
+// The size frames are scaled to before they are measured.
+const tuneWidth = 640
+const tuneHeight = 360
+
+// TuneMetrics describes the content of a video.
+type TuneMetrics struct {
+    // EdgeDensity is the ratio of pixels that lie on a sharp edge.
+    EdgeDensity float64
+    // FlatRatio is the ratio of 8x8 blocks whose pixels are all about the same brightness.
+    FlatRatio float64
+    // TemporalNoise is the average brightness change between two consecutive frames in the
+    // smooth areas of the frame.
+    TemporalNoise float64
+}
+
+// Tune returns the tuning profile of the video, which is none unless a profile is supplied with
+// the tune flag. With auto the profile is picked by sampling frames of the video:
+// * animation has large flat areas without noise.
+// * grain has noise that changes from frame to frame.
+// * digital has almost no noise at all, such as video shot on a digital camera.
+// * film is everything else.
+func (v *Video) Tune() string {
+    if v.tune != "" {
+        return v.tune
+    }
+    if GetParameters().Tune() != "auto" {
+        v.tune = GetParameters().Tune()
+        return v.tune
+    }
+    metrics := v.TuneMetrics()
+    v.tune = "film"
+    if metrics.TemporalNoise > 2.0 {
+        v.tune = "grain"
+    } else if metrics.FlatRatio > 0.35 && metrics.TemporalNoise < 1.5 && metrics.EdgeDensity < 0.15 {
+        v.tune = "animation"
+    } else if metrics.TemporalNoise < 0.5 {
+        v.tune = "digital"
+    }
+    Progressf("Tuning for %s content (edge density %.3f, flat ratio %.3f, temporal noise %.2f).", v.tune, metrics.EdgeDensity, metrics.FlatRatio, metrics.TemporalNoise)
+    return v.tune
+}
+
+// TuneMetrics measures pairs of consecutive frames taken from evenly spaced places in the video
+// and returns the median of each measurement.
+func (v *Video) TuneMetrics() TuneMetrics {
+    edges := []float64{}
+    flats := []float64{}
+    noises := []float64{}
+    for i := 1; i <= tuneSamples; i++ {
+        at := v.Seconds() * float64(i) / float64(tuneSamples + 1)
+        frames, err := grayFrames(v, at, 2)
+        if err != nil || len(frames) < 2 {
+            continue
+        }
+        edges = append(edges, edgeDensity(frames[0]))
+        flats = append(flats, flatRatio(frames[0]))
+        noises = append(noises, temporalNoise(frames[0], frames[1]))
+    }
+    return TuneMetrics{median(edges), median(flats), median(noises)}
+}
+
+// Decodes count frames starting at the time into cropped, scaled 8-bit gray frames.
+func grayFrames(v *Video, at float64, count int) ([][]byte, error) {
+    params := []string{}
+    params = append(params, "-ss", strconv.FormatFloat(at, 'f', 3, 64))
+    params = append(params, "-i", v.Path())
+    params = append(params, "-map", "0:v:0")
+    params = append(params, "-frames:v", strconv.Itoa(count))
+    params = append(params, "-vf", fmt.Sprintf("%s,scale=%d:%d,format=gray", v.Crop().Filter(), tuneWidth, tuneHeight))
+    params = append(params, "-f", "rawvideo")
+    params = append(params, "-")
+    stdout, _, err := Ffmpeg(params...)
+    if err != nil {
+        return nil, err
+    }
+    size := tuneWidth * tuneHeight
+    frames := [][]byte{}
+    for len(stdout) >= size {
+        frames = append(frames, stdout[:size])
+        stdout = stdout[size:]
+    }
+    return frames, nil
+}
+
+// Returns the sum of the horizontal and vertical brightness differences around the pixel.
+func gradient(frame []byte, x int, y int) int {
+    i := y * tuneWidth + x
+    return abs(int(frame[i + 1]) - int(frame[i - 1])) + abs(int(frame[i + tuneWidth]) - int(frame[i - tuneWidth]))
+}
+
+func edgeDensity(frame []byte) float64 {
+    edges := 0
+    pixels := 0
+    for y := 1; y < tuneHeight - 1; y++ {
+        for x := 1; x < tuneWidth - 1; x++ {
+            if gradient(frame, x, y) > 40 {
+                edges++
+            }
+            pixels++
+        }
+    }
+    return float64(edges) / float64(pixels)
+}
+
+func flatRatio(frame []byte) float64 {
+    flat := 0
+    blocks := 0
+    for by := 0; by + 8 <= tuneHeight; by += 8 {
+        for bx := 0; bx + 8 <= tuneWidth; bx += 8 {
+            low := 255
+            high := 0
+            for y := by; y < by + 8; y++ {
+                for x := bx; x < bx + 8; x++ {
+                    p := int(frame[y * tuneWidth + x])
+                    if p < low {
+                        low = p
+                    }
+                    if p > high {
+                        high = p
+                    }
+                }
+            }
+            if high - low <= 3 {
+                flat++
+            }
+            blocks++
+        }
+    }
+    return float64(flat) / float64(blocks)
+}
+
+// Only smooth areas are measured so that edges of moving objects are not counted as noise.
+func temporalNoise(first []byte, second []byte) float64 {
+    total := 0
+    pixels := 0
+    for y := 1; y < tuneHeight - 1; y++ {
+        for x := 1; x < tuneWidth - 1; x++ {
+            if gradient(first, x, y) >= 10 {
+                continue
+            }
+            i := y * tuneWidth + x
+            total += abs(int(second[i]) - int(first[i]))
+            pixels++
+        }
+    }
+    if pixels == 0 {
+        return 0
+    }
+    return float64(total) / float64(pixels)
+}
+
+func median(values []float64) float64 {
+    if len(values) == 0 {
+        return 0
+    }
+    sort.Float64s(values)
+    return values[len(values) / 2]
+}
+
+func abs(value int) int {
+    if value < 0 {
+        return -value
+    }
+    return value
+}
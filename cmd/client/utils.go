@@ -4,7 +4,6 @@ import (
     "io/ioutil"
     "os/exec"
     "os"
-    "fmt"
     "strings"
     "bufio"
     "runtime"
@@ -39,6 +38,16 @@ func DefaultMetadataDir() string {
     return path
 }
 
+// Returns the work directory of the title, where its video is optimized. Returns an empty string
+// when the metadata directory cannot be made.
+func workDir(title string) string {
+    metadata := DefaultMetadataDir()
+    if metadata == "" {
+        return ""
+    }
+    return filepath.Join(metadata, title)
+}
+
 func PathExists(path string) bool {
     if _, err := os.Stat(path); err == nil {
         return true
@@ -47,47 +56,48 @@ func PathExists(path string) bool {
 }
 
 func PrintFfprobe(params []string) {
-    fmt.Print("ffprobe")
-    for i, param := range params {
-        if strings.HasPrefix(param, "-") || i + 1 == len(params) {
-            fmt.Print("\n  " + param)
-        } else {
-            fmt.Print(" " + param)
-        }
-    }
-    fmt.Println("")
+    Debugf("%s", FormatCommand("ffprobe", params))
 }
 
 func PrintFfmpeg(params []string) {
-    fmt.Print("ffmpeg")
+    Debugf("%s", FormatCommand("ffmpeg", params))
+}
+
+// FormatCommand returns the command line with each option on its own line.
+func FormatCommand(name string, params []string) string {
+    command := name
     for i, param := range params {
         if strings.HasPrefix(param, "-") || i + 1 == len(params) {
-            fmt.Print("\n  " + param)
+            command = command + "\n  " + param
         } else {
-            fmt.Print(" " + param)
+            command = command + " " + param
         }
     }
-    fmt.Println("")
+    return command
 }
 
 func Copy(from string, to string) bool {
-    err := exec.Command("cp", from, to).Run()
-    return err == nil
+    cmd := command(commands, "cp", from, to)
+    return cmd.Run() == nil
 }
 
+// Move is not killed by the second interrupt; a move that is cut short could leave a title
+// without its video.
 func Move(from string, to string) error {
-    return exec.Command("mv", from, to).Run()
+    cmd := exec.Command("mv", from, to)
+    detach(cmd)
+    return cmd.Run()
 }
 
 func Remove(target string) bool {
-    err := exec.Command("rm", "-rf", target).Run()
-    return err == nil
+    cmd := command(commands, "rm", "-rf", target)
+    return cmd.Run() == nil
 }
 
 func Write(target string, text string) bool {
     err := os.WriteFile(target, []byte(text), 0644)
     if err != nil {
-        fmt.Println("Failed to write to temporary file: %v", err)
+        Errorf("Failed to write to %s: %v", target, err)
         return false
     }
     return true
@@ -96,25 +106,27 @@ func Write(target string, text string) bool {
 func Mkdir(target string) string {
     err := os.MkdirAll(target, os.ModePerm)
     if err != nil {
-        fmt.Println("Failed to make directory: %v", err)
+        Errorf("Failed to make directory %s: %v", target, err)
         return ""
     }
     return target
 }
 
+// MkTmpDir makes a temporary directory that is removed when the commands are killed. Remove it
+// with RemoveTmp.
 func MkTmpDir() string {
     tmpDir, err := ioutil.TempDir(os.TempDir(), GetBrand())
     if err != nil {
-        fmt.Println("Failed to make temporary directory: %v", err)
+        Errorf("Failed to make temporary directory: %v", err)
         return ""
     }
-    return tmpDir
+    return TrackTmp(tmpDir)
 }
 
 func Read(target string) []string {
     file, err := os.Open(target)
     if err != nil {
-        fmt.Println("Failed to open file: %s", target)
+        Errorf("Failed to open file: %s", target)
     }
     scanner := bufio.NewScanner(file)
     scanner.Split(bufio.ScanLines)
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Checks that each encoded scene has as many frames and lasts as long as the segment it was
+// encoded from, within the frame and duration tolerances.
+func verifyScenes(original *Video, segments []*Segment, scenes []*Video) error {
+    ratio, rounding := frameRatio(original)
+    for i, segment := range segments {
+        if i >= len(scenes) || !PathExists(scenes[i].Path()) {
+            return fmt.Errorf("scene %v was not encoded", i)
+        }
+        expected, err := countFrames(segment.Path)
+        if err != nil {
+            return fmt.Errorf("unable to count the frames of scene %v: %v", i, err)
+        }
+        actual, err := countFrames(scenes[i].Path())
+        if err != nil {
+            return fmt.Errorf("unable to count the frames of optimized scene %v: %v", i, err)
+        }
+        expected = int(math.Round(float64(expected) * ratio))
+        if !withinFrames(actual, expected, GetParameters().FrameTolerance() + rounding) {
+            return fmt.Errorf("optimized scene %v has %v frames but %v were expected", i, actual, expected)
+        }
+        if !withinSeconds(scenes[i].Seconds(), segment.Seconds()) {
+            return fmt.Errorf("optimized scene %v lasts %.3f seconds but %.3f were expected", i, scenes[i].Seconds(), segment.Seconds())
+        }
+    }
+    return nil
+}
+
+// Checks that the joined video has as many frames and lasts as long as the original video. Each
+// scene may differ by the frame tolerance.
+func verifyVideo(original *Video, segments []*Segment, optimized string) error {
+    ratio, rounding := frameRatio(original)
+    expected, err := countFrames(original.Path())
+    if err != nil {
+        return fmt.Errorf("unable to count the frames of %s: %v", original.Path(), err)
+    }
+    actual, err := countFrames(optimized)
+    if err != nil {
+        return fmt.Errorf("unable to count the frames of %s: %v", optimized, err)
+    }
+    expected = int(math.Round(float64(expected) * ratio))
+    if !withinFrames(actual, expected, (GetParameters().FrameTolerance() + rounding) * len(segments)) {
+        return fmt.Errorf("the optimized video has %v frames but %v were expected", actual, expected)
+    }
+    o := &Video{}
+    o.SetPath(optimized)
+    if !withinSeconds(o.Seconds(), original.Seconds()) {
+        return fmt.Errorf("the optimized video lasts %.3f seconds but %.3f were expected", o.Seconds(), original.Seconds())
+    }
+    Progressf("The optimized video has %v frames and lasts %.3f seconds as expected.", actual, o.Seconds())
+    return nil
+}
+
+// Returns the number of frames encoded for each source frame and the number of frames that may
+// be lost to rounding. The frame rate changes when the source's frame rate is rounded by Fps.
+func frameRatio(original *Video) (float64, int) {
+    rate := sourceFps(original.Path())
+    if rate <= 0 {
+        return 1, 0
+    }
+    ratio := fpsValue(original.Fps()) / rate
+    if ratio > 0.999 && ratio < 1.001 {
+        return 1, 0
+    }
+    return ratio, 1
+}
+
+func withinFrames(actual int, expected int, tolerance int) bool {
+    return actual >= expected - tolerance && actual <= expected + tolerance
+}
+
+func withinSeconds(actual float64, expected float64) bool {
+    return math.Abs(actual - expected) <= GetParameters().DurationTolerance()
+}
+
+// Returns the number of video frames in the file by counting its packets, which is much faster
+// than decoding it.
+func countFrames(path string) (int, error) {
+    stdout, err := Ffprobe(
+        "-select_streams", "v:0",
+        "-count_packets",
+        "-show_entries", "stream=nb_read_packets",
+        "-of", "csv=p=0",
+        path)
+    if err != nil {
+        return 0, err
+    }
+    return strconv.Atoi(strings.TrimSpace(strings.Split(string(stdout), "\n")[0]))
+}
+
+// Returns the average frame rate of the file as stored, before it is rounded by Fps.
+func sourceFps(path string) float64 {
+    return fpsValue(ProbeEntry(path, "v:0", "stream=avg_frame_rate"))
+}
@@ -2,11 +2,14 @@ package main
 
 import (
     "fmt"
-    "os/exec"
+    "regexp"
     "strings"
     "strconv"
 )
 
+var cropPattern = regexp.MustCompile(`crop=\d+:\d+:\d+:\d+`)
+var idetPattern = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)`)
+
 type Video struct {
 	name           string
 	path           string
@@ -21,6 +24,7 @@ type Video struct {
     duration       string
     fps            string
     progressive    *bool
+    tune           string
 }
 
 func (v *Video) Name() string {
@@ -39,15 +43,7 @@ func (v *Video) Width() int {
 	if v.width != nil {
 		return *v.width
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=width",
-		v.path)
-    stdout, _ := cmd.Output()
-    width, _ := strconv.Atoi(strings.TrimSuffix(string(stdout), "\n"))
+    width, _ := strconv.Atoi(ProbeEntry(v.path, "v:0", "stream=width"))
     v.width = &width
     return *v.width
 }
@@ -56,15 +52,7 @@ func (v *Video) Height() int {
 	if v.height != nil {
 		return *v.height
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=height",
-		v.path)
-    stdout, _ := cmd.Output()
-    height, _ := strconv.Atoi(strings.TrimSuffix(string(stdout), "\n"))
+    height, _ := strconv.Atoi(ProbeEntry(v.path, "v:0", "stream=height"))
     v.height = &height
     return *v.height
 }
@@ -73,15 +61,7 @@ func (v *Video) PixFmt() string {
 	if v.pixFmt != "" {
 		return v.pixFmt
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=pix_fmt",
-		v.path)
-    stdout, _ := cmd.Output()
-    v.pixFmt = strings.TrimSuffix(string(stdout), "\n")
+    v.pixFmt = ProbeEntry(v.path, "v:0", "stream=pix_fmt")
     return v.pixFmt
 }
 
@@ -89,15 +69,7 @@ func (v *Video) ColorPrimaries() string {
 	if v.colorPrimaries != "" {
 		return v.colorPrimaries
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=color_primaries",
-		v.path)
-    stdout, _ := cmd.Output()
-    v.colorPrimaries = strings.TrimSuffix(string(stdout), "\n")
+    v.colorPrimaries = ProbeEntry(v.path, "v:0", "stream=color_primaries")
     return v.colorPrimaries
 }
 
@@ -105,15 +77,7 @@ func (v *Video) Bitrate() int {
 	if v.bitrate != nil {
 		return *v.bitrate
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=bit_rate",
-		v.path)
-    stdout, _ := cmd.Output()
-    bitrateStr := strings.TrimSuffix(string(stdout), "\n")
+    bitrateStr := ProbeEntry(v.path, "v:0", "stream=bit_rate")
     if bitrateStr == "" || bitrateStr == "N/A" {
     	bitrate := -1
     	v.bitrate = &bitrate
@@ -128,15 +92,7 @@ func (v *Video) Dar() float64 {
 	if v.dar != nil {
 		return *v.dar
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=display_aspect_ratio",
-		v.path)
-    stdout, _ := cmd.Output()
-    darRatio := strings.TrimSuffix(string(stdout), "\n")
+    darRatio := ProbeEntry(v.path, "v:0", "stream=display_aspect_ratio")
     left, _ := strconv.ParseFloat(strings.Split(darRatio, ":")[0], 64)
     right, _ := strconv.ParseFloat(strings.Split(darRatio, ":")[1], 64)
     dar := left/right
@@ -148,15 +104,7 @@ func (v *Video) Sar() float64 {
 	if v.sar != nil {
 		return *v.sar
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=sample_aspect_ratio",
-		v.path)
-    stdout, _ := cmd.Output()
-    sarRatio := strings.TrimSuffix(string(stdout), "\n")
+    sarRatio := ProbeEntry(v.path, "v:0", "stream=sample_aspect_ratio")
     left, _ := strconv.ParseFloat(strings.Split(sarRatio, ":")[0], 64)
     right, _ := strconv.ParseFloat(strings.Split(sarRatio, ":")[1], 64)
     sar := left/right
@@ -173,9 +121,11 @@ func (v *Video) Crop() *Crop {
 		return v.crop
 	}
 	v.crop = &Crop{}
-	cmd := `ffmpeg -t 1000 -i "%s" -vf "select=not(mod(n\,1000)),cropdetect=36:1:0" -f null - 2>&1 | awk '/crop/ { print $NF }' | tail -1`
-    stdout, _ := exec.Command("bash","-c",fmt.Sprintf(cmd, v.path)).Output()
-    v.crop.filter = strings.TrimSuffix(string(stdout), "\n")
+    // cropdetect logs the crop it detected so far for each frame; the last one covers every frame.
+    _, stderr, _ := Ffmpeg("-t", "1000", "-i", v.path, "-vf", `select=not(mod(n\,1000)),cropdetect=36:1:0`, "-f", "null", "-")
+    if matches := cropPattern.FindAll(stderr, -1); len(matches) > 0 {
+        v.crop.filter = string(matches[len(matches) - 1])
+    }
 	if v.crop.filter != "" {
 		return v.crop
 	}
@@ -187,33 +137,22 @@ func (v *Video) Duration() string {
 	if v.duration != "" {
 		return v.duration
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "format=duration",
-		v.path)
-	stdout, _ := cmd.Output()
-	v.duration = strings.TrimSuffix(string(stdout), "\n")
+	v.duration = ProbeEntry(v.path, "v:0", "format=duration")
 	return v.duration
 }
 
+func (v *Video) Seconds() float64 {
+    seconds, _ := strconv.ParseFloat(v.Duration(), 64)
+    return seconds
+}
+
 func (v *Video) DurationMs() int {
     seconds, _ := strconv.ParseFloat(v.Duration(), 64)
     return int(seconds * 1000)
 }
 
 func calcFpsFromAvg(path string) string {
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=avg_frame_rate",
-		path)
-	stdout, _ := cmd.Output()
-    fpsRatio := strings.TrimSuffix(string(stdout), "\n")
+    fpsRatio := ProbeEntry(path, "v:0", "stream=avg_frame_rate")
     fpsLeft, _ := strconv.Atoi(strings.Split(fpsRatio, "/")[0])
     fpsRight, _ := strconv.Atoi(strings.Split(fpsRatio, "/")[1])
     fps := float64(fpsLeft) / float64(fpsRight)
@@ -239,15 +178,7 @@ func (v *Video) Fps() string {
 	if v.fps != "" {
 		return v.fps
 	}
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		"-show_entries", "stream=r_frame_rate",
-		v.path)
-	stdout, _ := cmd.Output()
-    fpsRatio := strings.TrimSuffix(string(stdout), "\n")
+    fpsRatio := ProbeEntry(v.path, "v:0", "stream=r_frame_rate")
     if fpsRatio == "" || strings.HasSuffix(fpsRatio, "/0") {
 		v.fps = calcFpsFromAvg(v.path)
 		return v.fps
@@ -301,13 +232,17 @@ func (v *Video) Progressive() bool {
 	if v.progressive != nil {
 		return *v.progressive
 	}
-	cmd := `ffmpeg -i "%s" -vf "idet" -f null - 2>&1 | tail -1`
-    stdout, _ := exec.Command("bash","-c",fmt.Sprintf(cmd, v.path)).Output()
-    idet := strings.TrimSuffix(string(stdout), "\n")
-    tff, _ := strconv.Atoi(strings.TrimSpace(strings.Split(strings.Split(idet, "TFF:")[1],"BFF:")[0]))
-    bff, _ := strconv.Atoi(strings.TrimSpace(strings.Split(strings.Split(idet, "BFF:")[1],"Progressive:")[0]))
-    pro, _ := strconv.Atoi(strings.TrimSpace(strings.Split(strings.Split(idet, "Progressive:")[1],"Undetermined:")[0]))
-    progressive := pro > tff + bff
+    // idet logs the totals of its multi frame detection last. Video that could not be analyzed
+    // is treated as progressive.
+    _, stderr, _ := Ffmpeg("-i", v.path, "-vf", "idet", "-f", "null", "-")
+    progressive := true
+    if matches := idetPattern.FindAllSubmatch(stderr, -1); len(matches) > 0 {
+        last := matches[len(matches) - 1]
+        tff, _ := strconv.Atoi(string(last[1]))
+        bff, _ := strconv.Atoi(string(last[2]))
+        pro, _ := strconv.Atoi(string(last[3]))
+        progressive = pro > tff + bff
+    }
     v.progressive = &progressive
     return *v.progressive
 }
@@ -337,15 +272,23 @@ func (v *Video) Filter(force720p bool) string {
     }
     // Denoise Video when enabled.
     // Do Not denoise on ultrafast mode as denoising slows things down
-    // Do not denoise on AV1 mode as AV1 does its own denoising during grain synthesis
+    // Do not denoise when the encoder does its own denoising during grain synthesis
+    // Do not denoise grainy video as the denoiser smears the grain
+    // Do not denoise clean digital video as it has no noise to remove
     // Only use the better nlmeans denoiser when when preset is not: ultrafast, superfast, veryfast, faster
     // Pixel format should end up in yuv420p10le.
-    if GetParameters().Ultrafast() || GetParameters().ForceAv1() || !GetParameters().Denoise() {
+    tune := v.Tune()
+    if GetParameters().Ultrafast() || len(GetParameters().Encoder().GrainArgs(tune)) > 0 || !GetParameters().Denoise() || tune == "grain" || tune == "digital" {
         if v.PixFmt() != "yuv420p10le" {
             vf = append(vf, "format=yuv420p10le")
         }
-    } else if GetParameters().PresetGroup() == 0 {
-        vf = append(vf, "hqdn3d=2:2:15:15")
+    } else if GetParameters().PresetGroup() == 0 || tune == "animation" {
+        // Animation has no fine detail to lose so the faster hqdn3d denoiser can be stronger.
+        if tune == "animation" {
+            vf = append(vf, "hqdn3d=3:3:6:6")
+        } else {
+            vf = append(vf, "hqdn3d=2:2:15:15")
+        }
         if v.PixFmt() != "yuv420p10le" {
             vf = append(vf, "format=yuv420p10le")
         }
@@ -356,6 +299,10 @@ func (v *Video) Filter(force720p bool) string {
         }
         vf = append(vf, "nlmeans='1.0:7:5:3:3'", "format=yuv420p10le")
     }
+    // Remove the banding of the large gradients in animation.
+    if tune == "animation" && !GetParameters().Ultrafast() {
+        vf = append(vf, "deband")
+    }
     // Migrate Video to 720p colorspace. Not doing so will cause playback issues on some players.
     if v.ColorPrimaries() == "unknown" {
         if v.Height() > 720 {
@@ -446,9 +393,9 @@ func (v *Video) Filter(force720p bool) string {
             wasScaled = true
         }
     }
-    // Convert back to 8bit only when forced to.
-    if GetParameters().Force8Bit() {
-        vf = append(vf, "format=yuv420p")
+    // Convert to the encoder's pixel format; only 8bit when forced to.
+    if format := pixelFormat(GetParameters().Encoder()); format != "yuv420p10le" {
+        vf = append(vf, "format=" + format)
     }
     // Run a light denoiser and light sharpener to clean up any jitters created by scaling.
     if !GetParameters().Ultrafast() && wasScaled {
@@ -0,0 +1,216 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Suffixes used by copy tools for files that are still being written.
+var PartialSuffixes []string = []string{".part", ".partial", ".tmp", ".crdownload", ".!qb", "~"}
+
+type titleState struct {
+    root      string
+    title     string
+    signature string
+    changed   time.Time
+    handled   string
+    queued    bool
+}
+
+type Watcher struct {
+    roots  []string
+    titles map[string]*titleState
+    queue  *Queue
+}
+
+// Watch keeps running and adds titles under the library roots to the job queue once their
+// files have stopped changing. Inotify (through inotifywait) is used
+// to notice changes as they happen; the roots are still scanned every interval since inotify
+// does not see changes made on network mounts. Stops on the first interrupt so that no titles are
+// queued while the daemon is stopping.
+func Watch(roots []string, q *Queue) {
+    Infof("### Watching %s.", strings.Join(roots, ", "))
+    w := &Watcher{}
+    w.roots = roots
+    w.titles = make(map[string]*titleState)
+    w.queue = q
+    wake := make(chan struct{}, 1)
+    // Stopping the watcher kills inotifywait, as does the second interrupt.
+    watching, stop := context.WithCancel(commands)
+    var stopped <-chan struct{}
+    if !GetParameters().Poll() {
+        var err error
+        if stopped, err = notify(watching, roots, wake); err != nil {
+            Warnf("Falling back to polling: %v", err)
+        }
+    }
+    for !Interrupted() {
+        next := w.scan()
+        select {
+        case <-wake:
+        case <-time.After(next):
+        case <-interrupted:
+        }
+    }
+    stop()
+    if stopped != nil {
+        <-stopped
+    }
+    Infof("### Stopped watching.")
+}
+
+// Scans every title folder under the roots and queues the ones that have settled.
+// Returns how long to wait before the next scan.
+func (w *Watcher) scan() time.Duration {
+    now := time.Now()
+    next := GetParameters().Interval()
+    seen := make(map[string]bool)
+    for _, root := range w.roots {
+        entries, err := ioutil.ReadDir(root)
+        if err != nil {
+            Errorf("%v", err)
+            continue
+        }
+        for _, entry := range entries {
+            if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+                continue
+            }
+            key := filepath.Join(root, entry.Name())
+            seen[key] = true
+            state, ok := w.titles[key]
+            if !ok {
+                state = &titleState{}
+                state.root = root
+                state.title = entry.Name()
+                w.titles[key] = state
+            }
+            if state.queued && w.queue.Active(root, state.title) {
+                continue
+            }
+            signature, newest, partial := snapshot(key)
+            // Remember what the title looks like once its job has finished so that our own
+            // changes do not cause it to be queued again.
+            if state.queued {
+                state.queued = false
+                state.signature = signature
+                state.handled = signature
+            }
+            if signature != state.signature {
+                state.signature = signature
+                state.changed = now
+            }
+            if signature == state.handled {
+                continue
+            }
+            // A title is only stable once its signature has stopped changing and none of
+            // its files have been written to for the whole settle period.
+            settledAt := state.changed
+            if newest.After(settledAt) {
+                settledAt = newest
+            }
+            settledAt = settledAt.Add(GetParameters().Settle())
+            if partial || now.Before(settledAt) {
+                wait := settledAt.Sub(now)
+                if partial || wait <= 0 {
+                    wait = GetParameters().Settle()
+                }
+                if wait < next {
+                    next = wait
+                }
+                continue
+            }
+            if GetMedia(root, state.title) == nil && !hasParts(key) {
+                state.handled = signature
+                continue
+            }
+            job, err := w.queue.Enqueue("", root, state.title)
+            if err != nil {
+                Errorf("Failed to queue %s: %v", state.title, err)
+                state.handled = signature
+                continue
+            }
+            Infof("Queued %s as job %v.", state.title, job.Id)
+            state.queued = true
+        }
+    }
+    for key, state := range w.titles {
+        if !seen[key] && !state.queued {
+            delete(w.titles, key)
+        }
+    }
+    return next
+}
+
+// Returns a signature built from the name, size and modification time of every file in
+// the directory, the newest modification time, and whether a file is still being copied.
+func snapshot(path string) (string, time.Time, bool) {
+    newest := time.Time{}
+    partial := false
+    files, err := ioutil.ReadDir(path)
+    if err != nil {
+        return "", newest, false
+    }
+    lines := make([]string, 0)
+    for _, file := range files {
+        if file.IsDir() {
+            continue
+        }
+        name := strings.ToLower(file.Name())
+        // rsync and friends write to hidden temporary files before renaming them into place.
+        if strings.HasPrefix(name, ".") && name != ".ds_store" {
+            partial = true
+        }
+        for _, suffix := range PartialSuffixes {
+            if strings.HasSuffix(name, suffix) {
+                partial = true
+            }
+        }
+        if file.ModTime().After(newest) {
+            newest = file.ModTime()
+        }
+        lines = append(lines, fmt.Sprintf("%s|%v|%v", file.Name(), file.Size(), file.ModTime().UnixNano()))
+    }
+    sort.Strings(lines)
+    return strings.Join(lines, "\n"), newest, partial
+}
+
+// Uses inotifywait from inotify-tools to wake the watcher whenever a file under the roots changes.
+// inotifywait is killed when the context is done. Returns a channel that is closed once it has
+// exited.
+func notify(ctx context.Context, roots []string, wake chan<- struct{}) (<-chan struct{}, error) {
+    params := []string{}
+    params = append(params, "-m", "-r", "-q")
+    params = append(params, "-e", "create,close_write,moved_to,moved_from,delete")
+    params = append(params, "--format", "%w%f")
+    params = append(params, roots...)
+    cmd := command(ctx, "inotifywait", params...)
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err = cmd.Start(); err != nil {
+        return nil, err
+    }
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        scanner := bufio.NewScanner(stdout)
+        for scanner.Scan() {
+            select {
+            case wake <- struct{}{}:
+            default:
+            }
+        }
+        err := cmd.Wait()
+        if ctx.Err() == nil {
+            Warnf("Stopped watching for changes: %v", err)
+        }
+    }()
+    return stopped, nil
+}